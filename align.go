@@ -0,0 +1,247 @@
+// Copyright (C) 2018 Michael Picht
+//
+// This file is part of gool (Online TV Recorder on Linux in Go).
+//
+// gool is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// gool is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with gool. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+// align.go implements the alignment of cutlists that have been created for
+// another version of the same broadcast (e.g. for the HQ version while the SD
+// version is cut). The versions start at slightly different offsets. Thus,
+// the cuts of such a cutlist need to be shifted. The time offset between the
+// file the cutlist has been created for and the video is determined as
+// follows:
+// - If the other file is available locally, the offset is determined by
+//   cross-correlating the audio tracks of both files (if switched on in the
+//   configuration).
+// - Otherwise, the offset is calculated from the difference of the broadcast
+//   start times (from the file names) and the configured offsets per quality.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Constants for the audio cross-correlation
+const (
+	alignSampleRate = 4000  // sample rate (Hz) of the extracted audio
+	alignWindow     = 40    // number of samples per envelope value (=10ms)
+	alignDur        = 300.0 // length (in seconds) of the compared audio
+	alignMaxLag     = 30.0  // maximum offset (in seconds) that is searched for
+	alignMinCorr    = 0.5   // minimum correlation for a reliable result
+)
+
+// parseQualityOffsets parses the configured offsets per quality. The format is
+// "quality=seconds,..." (e.g. "HQ=0,HD=0.4,SD=-1.2"). The offset of a quality
+// is the time at which a certain scene appears in a file of that quality,
+// relative to a reference (only differences between qualities are relevant)
+func parseQualityOffsets(s string) (map[string]float64, error) {
+	offs := make(map[string]float64)
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e == "" {
+			continue
+		}
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Quality offset '%s' has not the format quality=seconds", e)
+		}
+		off, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("Quality offset '%s' cannot be interpreted: %v", e, err)
+		}
+		offs[strings.ToUpper(strings.TrimSpace(kv[0]))] = off
+	}
+	return offs, nil
+}
+
+// alignCutlist shifts the cuts of the cutlist of the video if the cutlist has
+// been created for another version of the broadcast. If the offset cannot be
+// determined, an error is returned
+func (v *video) alignCutlist() error {
+	cl := v.cl
+	if cl.applyTo == "" || v.isFileOfVideo(cl.applyTo) {
+		return nil
+	}
+
+	// parse the name of the file the cutlist has been created for
+	ri, _, _, err := parseFileName(filepath.Base(cl.applyTo))
+	if err != nil {
+		return fmt.Errorf("Cutlist has been created for %s. Its offset cannot be determined: %v", cl.applyTo, err)
+	}
+
+	var (
+		off    float64
+		method string
+	)
+
+	// determine offset by audio cross-correlation if the other file is
+	// available. Otherwise, calculate it from start times and quality offsets
+	if other := v.siblingFile(ri); cfg.alignAudio && other != "" {
+		if off, err = v.audioOffset(other); err != nil {
+			log.WithFields(log.Fields{"key": v.key}).Warnf("Audio alignment with %s failed: %v", other, err)
+		} else {
+			method = "audio correlation with " + filepath.Base(other)
+		}
+	}
+	if method == "" {
+		off = ri.start.Sub(v.ri.start).Seconds() + cfg.qualityOffsets[strings.ToUpper(v.ri.quality)] - cfg.qualityOffsets[strings.ToUpper(ri.quality)]
+		method = "start time and quality offsets"
+	}
+
+	cl.shift(off)
+	cl.reason += fmt.Sprintf(", shifted by %+.2fs (%s)", off, method)
+	log.WithFields(log.Fields{"key": v.key}).Infof("Cutlist for %s has been shifted by %+.2fs (%s)", cl.applyTo, off, method)
+
+	return nil
+}
+
+// shift shifts all segments of the cutlist by off seconds. Segments that
+// would start before the beginning of the video are shortened
+func (cl *cutlist) shift(off float64) {
+	var segs []*seg
+	for _, sg := range cl.segs {
+		sg.timeStart += off
+		if sg.timeStart < 0 {
+			sg.timeDur += sg.timeStart
+			sg.timeStart = 0
+		}
+		if cl.fps > 0 {
+			sg.frameStart += int(math.Floor(off*cl.fps + 0.5))
+			if sg.frameStart < 0 {
+				sg.frameDur += sg.frameStart
+				sg.frameStart = 0
+			}
+		}
+		if (cl.timeBased && sg.timeDur <= 0) || (!cl.timeBased && sg.frameDur <= 0) {
+			continue
+		}
+		segs = append(segs, sg)
+	}
+	cl.segs = segs
+}
+
+// siblingFile returns the path of the decoded file of another version (ri) of
+// the broadcast if it exists in the directory of the video, the directory for
+// decoded videos or the archive. Otherwise, an empty string is returned
+func (v *video) siblingFile(ri *recInfo) string {
+	name := ri.fileName(ri.quality)
+	for _, dir := range []string{filepath.Dir(v.filePath), cfg.decDirPath, cfg.arcDirPath} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return filepath.Join(dir, name)
+		}
+	}
+	return ""
+}
+
+// audioOffset determines the offset (in seconds) of the video relative to the
+// file other by cross-correlating the audio envelopes of the beginning of
+// both files
+func (v *video) audioOffset(other string) (float64, error) {
+	a, err := audioEnvelope(other)
+	if err != nil {
+		return 0, err
+	}
+	b, err := audioEnvelope(v.filePath)
+	if err != nil {
+		return 0, err
+	}
+
+	lag, corr := crossCorrelate(a, b, int(alignMaxLag*alignSampleRate/alignWindow))
+	if corr < alignMinCorr {
+		return 0, fmt.Errorf("Audio tracks don't correlate sufficiently (%.2f)", corr)
+	}
+
+	return float64(lag) * alignWindow / alignSampleRate, nil
+}
+
+// audioEnvelope extracts the beginning of the audio track of a file with
+// FFmpeg (mono, low sample rate) and returns its envelope, i.e. the mean
+// absolute amplitude per window
+func audioEnvelope(filePath string) ([]float64, error) {
+	cmd := exec.Command(ffmpegName,
+		"-v", "error",
+		"-t", strconv.FormatFloat(alignDur, 'f', 0, 64),
+		"-i", filePath,
+		"-vn", "-ac", "1", "-ar", strconv.Itoa(alignSampleRate),
+		"-f", "s16le", "pipe:1")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Audio of %s cannot be extracted: %v", filePath, err)
+	}
+
+	samples := make([]int16, len(out)/2)
+	if err = binary.Read(bytes.NewReader(out[:2*len(samples)]), binary.LittleEndian, samples); err != nil {
+		return nil, err
+	}
+
+	env := make([]float64, len(samples)/alignWindow)
+	for i := range env {
+		var sum float64
+		for _, s := range samples[i*alignWindow : (i+1)*alignWindow] {
+			sum += math.Abs(float64(s))
+		}
+		env[i] = sum / alignWindow
+	}
+
+	return env, nil
+}
+
+// crossCorrelate determines the lag (in the range of [-maxLag, maxLag]) at
+// which b fits best to a, i.e. b[i] corresponds to a[i-lag]. It returns the
+// lag and the normalized correlation at that lag
+func crossCorrelate(a, b []float64, maxLag int) (int, float64) {
+	var (
+		bestLag  int
+		bestCorr = -1.0
+	)
+
+	for lag := -maxLag; lag <= maxLag; lag++ {
+		var sa, sb, saa, sbb, sab, n float64
+		for i := range b {
+			j := i - lag
+			if j < 0 || j >= len(a) {
+				continue
+			}
+			sa += a[j]
+			sb += b[i]
+			saa += a[j] * a[j]
+			sbb += b[i] * b[i]
+			sab += a[j] * b[i]
+			n++
+		}
+		// require an overlap of at least half of the compared audio
+		if n < float64(len(b))/2 {
+			continue
+		}
+		d := math.Sqrt((saa - sa*sa/n) * (sbb - sb*sb/n))
+		if d == 0 {
+			continue
+		}
+		if corr := (sab - sa*sb/n) / d; corr > bestCorr {
+			bestLag, bestCorr = lag, corr
+		}
+	}
+
+	return bestLag, bestCorr
+}
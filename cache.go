@@ -0,0 +1,187 @@
+// Copyright (C) 2018 Michael Picht
+//
+// This file is part of gool (Online TV Recorder on Linux in Go).
+//
+// gool is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// gool is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with gool. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+// cache.go implements a local cache for data that is retrieved from the
+// cutlist server. The cache is stored in the sub directory "cache" of the
+// working dir:
+// - The cutlist headers (XML) are stored per query (e.g. "name=<key>") in
+//   the sub directory "headers". They expire after the configured TTL, since
+//   new cutlists or ratings can be added on the server.
+// - The cutlists (INI) are stored per ID in the sub directory "cutlists".
+//   They don't expire, since a cutlist doesn't change once it's uploaded.
+// In offline mode, the cutlist server is not called at all. Only cached data
+// is used (independent of its age).
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Constants for the sub directories of the cache
+const (
+	cacheDirNameHeaders  = "headers"
+	cacheDirNameCutlists = "cutlists"
+)
+
+// Constants for the suffices of cache files
+const (
+	cacheSuffixHeaders = ".xml"
+	cacheSuffixCutlist = ".cutlist"
+)
+
+// readCache returns the content of the cache file filePath. If the file
+// doesn't exist or is older than ttl, false is returned. If ttl is negative,
+// the age of the file is not checked.
+func readCache(filePath string, ttl time.Duration) ([]byte, bool) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, false
+	}
+	if ttl >= 0 && time.Since(info.ModTime()) > ttl {
+		log.Debugf("Cache file %s is expired", filePath)
+		return nil, false
+	}
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Errorf("Cache file %s cannot be read: %v", filePath, err)
+		return nil, false
+	}
+	log.Debugf("Read %s from cache", filePath)
+	return data, true
+}
+
+// writeCache stores data in the cache file filePath. Errors are logged only
+func writeCache(filePath string, data []byte) {
+	if err := writeFileAtomic(filePath, data); err != nil {
+		log.Errorf("Cache file %s cannot be written: %v", filePath, err)
+	}
+}
+
+// writeFileAtomic writes data into the file filePath. The directory of the
+// file is created if necessary. The data is written into a temporary file
+// first that is renamed afterwards. This makes sure that concurrent readers
+// never see incomplete files.
+func writeFileAtomic(filePath string, data []byte) error {
+	dir := path.Dir(filePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	_ = f.Close()
+	if err == nil {
+		err = os.Rename(f.Name(), filePath)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+// httpGet calls the URL and returns the body of the response
+func httpGet(u string) ([]byte, error) {
+	resp, err := http.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %s", u, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// fetchCutlistHeaders retrieves the cutlist headers (XML) for a query (e.g.
+// "name=<key>") from the cache or - if they aren't cached or expired - from
+// the cutlist server
+func fetchCutlistHeaders(query string) ([]byte, error) {
+	var (
+		data []byte
+		ok   bool
+		err  error
+		ttl  = cfg.clsCacheTTL
+	)
+
+	filePath := cfg.cacheDirPath + "/" + cacheDirNameHeaders + "/" + url.QueryEscape(query) + cacheSuffixHeaders
+
+	// in offline mode, cached data is used independent of its age
+	if cfg.offline {
+		ttl = -1
+	}
+	if data, ok = readCache(filePath, ttl); ok {
+		return data, nil
+	}
+	if cfg.offline {
+		return nil, fmt.Errorf("Offline mode: No cached cutlist headers for '%s'", query)
+	}
+
+	// load cutlist headers from cutlist server
+	log.Debugf("Call cutlist server: %sgetxml.php?%s", cfg.clsURL, query)
+	if data, err = httpGet(cfg.clsURL + "getxml.php?" + query); err != nil {
+		return nil, err
+	}
+	writeCache(filePath, data)
+
+	return data, nil
+}
+
+// cutlistCachePath returns the path of the cache file of the cutlist with
+// the given ID
+func cutlistCachePath(id string) string {
+	return cfg.cacheDirPath + "/" + cacheDirNameCutlists + "/" + url.PathEscape(id) + cacheSuffixCutlist
+}
+
+// fetchCutlistFile retrieves the cutlist (INI) with the given ID from the
+// cache or - if it isn't cached - from the cutlist server
+func fetchCutlistFile(id string) ([]byte, error) {
+	var (
+		data []byte
+		ok   bool
+		err  error
+	)
+
+	filePath := cutlistCachePath(id)
+
+	// cutlists don't change: Cached data is always used
+	if data, ok = readCache(filePath, -1); ok {
+		return data, nil
+	}
+	if cfg.offline {
+		return nil, fmt.Errorf("Offline mode: Cutlist ID=%s is not cached", id)
+	}
+
+	// load cutlist from cutlist server
+	log.Debugf("Call cutlist server: %sgetfile.php?id=%s", cfg.clsURL, id)
+	if data, err = httpGet(cfg.clsURL + "getfile.php?id=" + url.QueryEscape(id)); err != nil {
+		return nil, err
+	}
+	writeCache(filePath, data)
+
+	return data, nil
+}
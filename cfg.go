@@ -40,6 +40,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-ini/ini"
 	log "github.com/sirupsen/logrus"
@@ -52,12 +53,31 @@ const (
 	cfgSectionGeneral = "general"
 	cfgSectionDecode  = "decode"
 	cfgSectionCut     = "cut"
+	cfgSectionCL      = "cutlist"
 	cfgKeyWrkDir      = "working_dir"
 	cfgKeyNumCPUs     = "num_cpus_for_gool"
 	cfgKeyOTRDecDir   = "otr_decoder_dir"
 	cfgKeyOTRUsername = "otr_username"
 	cfgKeyOTRPassword = "otr_password"
 	cfgKeyCLSUrl      = "cutlist_server_url"
+	cfgKeyCutter      = "cutter"
+	cfgKeyAccurateCut = "accurate_cut"
+	cfgKeyNameTmpl    = "name_template"
+	cfgKeyNameColl    = "name_collision"
+	cfgKeyChapters    = "chapters"
+	cfgKeySetMTime    = "set_mtime"
+	cfgKeyProvenance  = "provenance"
+	cfgKeyMarkOnly    = "mark_only"
+	cfgKeyCLSCacheTTL = "cache_ttl_hours"
+	cfgKeyCLDir       = "cutlist_dir"
+	cfgKeyCLMinRating = "min_rating"
+	cfgKeyCLMinCount  = "min_rating_count"
+	cfgKeyCLTrusted   = "trusted_authors"
+	cfgKeyCLBlocked   = "blocked_authors"
+	cfgKeyCLRejectErr = "reject_errors"
+	cfgKeyCLQualOffs  = "quality_offsets"
+	cfgKeyCLAlignAud  = "align_audio"
+	cfgKeyCLUserID    = "user_id"
 )
 
 // Constants for directory names
@@ -67,6 +87,7 @@ const (
 	subDirNameCut = "Cut"
 	subDirNameArc = "Decoded/Archive"
 	subDirNameLog = "log"
+	subDirNameCch = "cache"
 )
 
 // Constants for error file suffices
@@ -82,18 +103,37 @@ const (
 
 // config contains the content read from the gool config file
 type config struct {
-	wrkDirPath    string // working dir for gool
-	encDirPath    string // dir for encoded videos
-	decDirPath    string // dir for decoded videos
-	cutDirPath    string // dir for cut videos
-	logDirPath    string // dir for log files
-	arcDirPath    string // dir for archived decoded videos (to be able to repeat the cut)
-	numCpus       int    // number of CPUs that gool is allowed to use
-	otrDecDirPath string // directory where otrdecoder is stored
-	otrUsername   string // username for OTR
-	otrPassword   string // password for OTR
-	clsURL        string // URL of custlist server
-	doCleanUp     bool   // delete files that are no longer needed
+	wrkDirPath     string             // working dir for gool
+	encDirPath     string             // dir for encoded videos
+	decDirPath     string             // dir for decoded videos
+	cutDirPath     string             // dir for cut videos
+	logDirPath     string             // dir for log files
+	arcDirPath     string             // dir for archived decoded videos (to be able to repeat the cut)
+	cacheDirPath   string             // dir for cached data from the cutlist server
+	numCpus        int                // number of CPUs that gool is allowed to use
+	otrDecDirPath  string             // directory where otrdecoder is stored
+	otrUsername    string             // username for OTR
+	otrPassword    string             // password for OTR
+	clsURL         string             // URL of custlist server
+	cutter         string             // name of the program that is used for cutting
+	accurateCut    bool               // cut frame accurately (re-encode at cut boundaries)
+	nameTmpl       string             // template for the names of cut videos
+	nameColl       string             // strategy if a cut video with the same name exists
+	mkvChapters    bool               // add chapters where ad breaks have been removed (MKVmerge only)
+	setMTime       bool               // set modification time of cut videos to broadcast time
+	provenance     bool               // write a provenance sidecar for each cut video
+	markOnly       bool               // write skip files instead of cutting videos
+	clsCacheTTL    time.Duration      // time after which cached cutlist headers expire
+	offline        bool               // don't call the cutlist server, use cached data only
+	clDirPath      string             // dir for local cutlist files
+	clPolicy       clPolicy           // policy for the selection of cutlists
+	qualityOffsets map[string]float64 // offsets (in seconds) per quality for the alignment of cutlists
+	alignAudio     bool               // align cutlists by audio cross-correlation
+	clUserID       string             // user ID for the upload and rating of cutlists
+	libRootPath    string             // root dir of the media server library (empty: no export)
+	libMovieDur    int                // minimum duration (in minutes) of movies
+	libRules       []libRule          // rules for the classification of library items
+	doCleanUp      bool               // delete files that are no longer needed
 }
 
 // global config structure
@@ -235,6 +275,9 @@ func (cfg *config) getFromFile() error {
 	if cfg.logDirPath, err = getSubDirPath(subDirNameLog); err != nil {
 		return err
 	}
+	if cfg.cacheDirPath, err = getSubDirPath(subDirNameCch); err != nil {
+		return err
+	}
 
 	// Read NUM_CPUS_FOR_GOOL key. If it doesn't exist: Create it.
 	if key, err = getKey(cfgFile, sec, cfgKeyNumCPUs, getNumCPUsFromKeyboard, &hasChanged); err != nil {
@@ -277,6 +320,138 @@ func (cfg *config) getFromFile() error {
 	}
 	cfg.clsURL = key.Value()
 
+	// Read CUTTER key. If it doesn't exist: Create it with default value.
+	if key, err = getOptKey(sec, cfgKeyCutter, cutterMKVmerge, &hasChanged); err != nil {
+		return err
+	}
+	cfg.cutter = key.Value()
+
+	// Read ACCURATE_CUT key. If it doesn't exist: Create it with default value.
+	if key, err = getOptKey(sec, cfgKeyAccurateCut, "false", &hasChanged); err != nil {
+		return err
+	}
+	cfg.accurateCut = key.MustBool(false)
+
+	// Read NAME_TEMPLATE key. If it doesn't exist: Create it with default value.
+	if key, err = getOptKey(sec, cfgKeyNameTmpl, nameTmplDefault, &hasChanged); err != nil {
+		return err
+	}
+	if cfg.nameTmpl = key.Value(); cfg.nameTmpl == "" {
+		cfg.nameTmpl = nameTmplDefault
+	}
+	if err = checkNameTemplate(cfg.nameTmpl); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	// Read NAME_COLLISION key. If it doesn't exist: Create it with default value.
+	if key, err = getOptKey(sec, cfgKeyNameColl, nameCollSuffix, &hasChanged); err != nil {
+		return err
+	}
+	cfg.nameColl = strings.ToLower(key.Value())
+	if err = checkNameCollision(cfg.nameColl); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	// Read CHAPTERS key. If it doesn't exist: Create it with default value.
+	if key, err = getOptKey(sec, cfgKeyChapters, "false", &hasChanged); err != nil {
+		return err
+	}
+	cfg.mkvChapters = key.MustBool(false)
+
+	// Read SET_MTIME key. If it doesn't exist: Create it with default value.
+	if key, err = getOptKey(sec, cfgKeySetMTime, "true", &hasChanged); err != nil {
+		return err
+	}
+	cfg.setMTime = key.MustBool(true)
+
+	// Read PROVENANCE key. If it doesn't exist: Create it with default value.
+	if key, err = getOptKey(sec, cfgKeyProvenance, "true", &hasChanged); err != nil {
+		return err
+	}
+	cfg.provenance = key.MustBool(true)
+
+	// Read MARK_ONLY key. If it doesn't exist: Create it with default value.
+	if key, err = getOptKey(sec, cfgKeyMarkOnly, "false", &hasChanged); err != nil {
+		return err
+	}
+	cfg.markOnly = key.MustBool(false)
+
+	// Get CUTLIST section. If it doesn't exist: Create it.
+	if sec, err = getSection(cfgFile, cfgSectionCL, &hasChanged); err != nil {
+		return err
+	}
+
+	// Read CACHE_TTL_HOURS key. If it doesn't exist: Create it with default value.
+	if key, err = getOptKey(sec, cfgKeyCLSCacheTTL, "24", &hasChanged); err != nil {
+		return err
+	}
+	cfg.clsCacheTTL = time.Duration(key.MustFloat64(24) * float64(time.Hour))
+
+	// Read CUTLIST_DIR key. If it doesn't exist: Create it with empty value.
+	if key, err = getOptKey(sec, cfgKeyCLDir, "", &hasChanged); err != nil {
+		return err
+	}
+	cfg.clDirPath = key.Value()
+
+	// Read MIN_RATING key. If it doesn't exist: Create it with default value.
+	if key, err = getOptKey(sec, cfgKeyCLMinRating, "0", &hasChanged); err != nil {
+		return err
+	}
+	cfg.clPolicy.minRating = key.MustFloat64(0)
+
+	// Read MIN_RATING_COUNT key. If it doesn't exist: Create it with default value.
+	if key, err = getOptKey(sec, cfgKeyCLMinCount, "0", &hasChanged); err != nil {
+		return err
+	}
+	cfg.clPolicy.minRatingCount = key.MustInt(0)
+
+	// Read TRUSTED_AUTHORS key. If it doesn't exist: Create it with empty value.
+	if key, err = getOptKey(sec, cfgKeyCLTrusted, "", &hasChanged); err != nil {
+		return err
+	}
+	cfg.clPolicy.trusted = authorSet(key.Value())
+
+	// Read BLOCKED_AUTHORS key. If it doesn't exist: Create it with empty value.
+	if key, err = getOptKey(sec, cfgKeyCLBlocked, "", &hasChanged); err != nil {
+		return err
+	}
+	cfg.clPolicy.blocked = authorSet(key.Value())
+
+	// Read REJECT_ERRORS key. If it doesn't exist: Create it with default value.
+	if key, err = getOptKey(sec, cfgKeyCLRejectErr, "true", &hasChanged); err != nil {
+		return err
+	}
+	cfg.clPolicy.rejectErrors = key.MustBool(true)
+
+	// Read QUALITY_OFFSETS key. If it doesn't exist: Create it with empty value.
+	if key, err = getOptKey(sec, cfgKeyCLQualOffs, "", &hasChanged); err != nil {
+		return err
+	}
+	if cfg.qualityOffsets, err = parseQualityOffsets(key.Value()); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	// Read ALIGN_AUDIO key. If it doesn't exist: Create it with default value.
+	if key, err = getOptKey(sec, cfgKeyCLAlignAud, "true", &hasChanged); err != nil {
+		return err
+	}
+	cfg.alignAudio = key.MustBool(true)
+
+	// Read USER_ID key. If it doesn't exist: Create it with default value.
+	if key, err = getOptKey(sec, cfgKeyCLUserID, "", &hasChanged); err != nil {
+		return err
+	}
+	cfg.clUserID = key.Value()
+
+	// Read LIBRARY section
+	if err = cfg.readLibConfig(cfgFile, &hasChanged); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
 	// if entries of the configuration file have been changed is needs to be saved
 	if hasChanged {
 		log.Debug("Config has been changed and needs to be saved")
@@ -351,6 +526,33 @@ func getKey(iniFile *ini.File, sec *ini.Section, keyName string, f getFromKeyboa
 	return sec.Key(keyName), err
 }
 
+// Checks if an optional key exists in ini file. If it doesn't, it's created with
+// the default value def (the user is not asked). In contrast to getKey, an empty
+// value is accepted. In case of success, the key is returned. In addition, a flag
+// is returned that indicates whether file has been changed or not
+func getOptKey(sec *ini.Section, keyName string, def string, hasChanged *bool) (*ini.Key, error) {
+	var err error
+
+	// If key exists: Get key and return
+	if sec.HasKey(keyName) {
+		log.Debugf("[%s].%s=%s", sec.Name(), keyName, sec.Key(keyName).Value())
+		return sec.Key(keyName), nil
+	}
+
+	// Configuration needs to be saved
+	*hasChanged = true
+
+	// Create key with default value
+	if _, err = sec.NewKey(keyName, def); err != nil {
+		log.Errorf("Key %s cannot be created: %v", keyName, err)
+		err = fmt.Errorf("Key %s cannot be created: %v", keyName, err)
+	}
+
+	log.Debugf("[%s].%s=%s", sec.Name(), keyName, sec.Key(keyName).Value())
+
+	return sec.Key(keyName), err
+}
+
 // Asks the user to enter the number of cpus to be used for gool
 func getNumCPUsFromKeyboard() (string, error) {
 	var (
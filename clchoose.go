@@ -0,0 +1,195 @@
+// Copyright (C) 2018 Michael Picht
+//
+// This file is part of gool (Online TV Recorder on Linux in Go).
+//
+// gool is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// gool is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with gool. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+// clchoose.go implements the manual selection of cutlists: Either the ID of
+// the cutlist is passed via command line (flag --cutlist-id), or the user
+// chooses a cutlist interactively from the cutlists that the cutlist server
+// offers (flag --choose). In both cases, the automatic selection based on the
+// cutlist policy is bypassed.
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// assignCutlistIDs assigns the cutlist IDs that have been passed via command
+// line to the videos of the list. Each spec has the format "[video=]id": If
+// video (a key or a file name of a video) is given, the cutlist is assigned
+// to that video. Otherwise, it's assigned to the only video that is to be
+// processed.
+func (vl videoList) assignCutlistIDs(specs []string) error {
+	for _, spec := range specs {
+		var (
+			vid string
+			id  = spec
+			v   *video
+		)
+		if i := strings.Index(spec, "="); i >= 0 {
+			vid, id = spec[:i], spec[i+1:]
+		}
+		if id == "" {
+			return fmt.Errorf("Cutlist ID is missing in '%s'", spec)
+		}
+
+		// find video for cutlist ID
+		if vid != "" {
+			for _, w := range vl {
+				if w.key == vid || w.isFileOfVideo(vid) {
+					v = w
+					break
+				}
+			}
+		} else {
+			var open []*video
+			for _, w := range vl {
+				if !w.isDone() {
+					open = append(open, w)
+				}
+			}
+			if len(open) == 1 {
+				v = open[0]
+			}
+		}
+		if v == nil {
+			return fmt.Errorf("No video found for cutlist ID %s. Use the format video=id", id)
+		}
+
+		log.WithFields(log.Fields{"key": v.key}).Infof("Use cutlist ID=%s", id)
+		v.clID = id
+	}
+
+	return nil
+}
+
+// chooseCutlists lets the user choose the cutlist for each video that is to
+// be cut. For each cutlist, its author, rating, comment, number of cuts, the
+// duration of the cut video and its error flags are displayed. Videos that
+// have local cutlist files or a cutlist ID that has been passed via command
+// line are skipped.
+func (vl videoList) chooseCutlists() {
+	var keys []string
+	for key, v := range vl {
+		if !v.isDone() && len(v.clFiles) == 0 && v.clID == "" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	sort.Strings(keys)
+
+	fmt.Printf("\n\033[1m\033[34m:: Choose cutlists ...\033[22m\033[39m\n")
+
+	in := bufio.NewReader(os.Stdin)
+	for _, key := range keys {
+		v := vl[key]
+
+		fmt.Printf("\n\033[1m%s\033[22m\n", v.key)
+
+		// load cutlist headers. The accepted ones are listed first
+		_ = v.loadCutlistHeaders()
+		clhs := append(v.clhs.accepted(), v.clhs.rejected()...)
+		if len(clhs) == 0 {
+			fmt.Println("  No cutlists found")
+			continue
+		}
+
+		// print cutlists
+		for i, clh := range clhs {
+			fmt.Printf("%3d) %s\n", i+1, clh.describe())
+		}
+
+		// ask user for choice
+		for {
+			fmt.Printf("Choose cutlist [1-%d, Enter: automatic selection]: ", len(clhs))
+			input, _ := in.ReadString('\n')
+			if input = strings.TrimSpace(input); input == "" {
+				break
+			}
+			if n, err := strconv.Atoi(input); err == nil && n >= 1 && n <= len(clhs) {
+				v.clID = clhs[n-1].id
+				log.WithFields(log.Fields{"key": v.key}).Infof("Cutlist ID=%s has been chosen", v.clID)
+				break
+			}
+			fmt.Println("Invalid input")
+		}
+	}
+}
+
+// rejected returns the cutlist headers that have been rejected
+func (clhs clHeaders) rejected() clHeaders {
+	var rej clHeaders
+	for _, clh := range clhs {
+		if clh.rejected {
+			rej = append(rej, clh)
+		}
+	}
+	return rej
+}
+
+// describe returns a (multi line) description of a cutlist for the interactive
+// selection. To get the number of cuts and the duration of the cut video, the
+// cutlist is loaded from the cache or the cutlist server
+func (clh *clHeader) describe() string {
+	s := fmt.Sprintf("ID %s by %s, rating %.2f (%d ratings)", clh.id, clh.author, clh.effRating(), clh.ratingCount)
+	if data, err := fetchCutlistFile(clh.id); err != nil {
+		s += "\n       Cutlist cannot be loaded"
+	} else if cl, err := parseCutlist(clh.id, data); err != nil {
+		s += "\n       Cutlist cannot be parsed"
+	} else {
+		s += fmt.Sprintf("\n       %d cuts, duration %s", len(cl.segs), timeStr(cl.cutDur())[:8])
+	}
+	if len(clh.errors) > 0 {
+		s += "\n       Errors: " + strings.Join(clh.errors, ", ")
+	}
+	if clh.comment != "" {
+		s += "\n       Comment: " + clh.comment
+	}
+	if clh.rejected {
+		s += "\n       \033[31mNot recommended: " + clh.reason + "\033[39m"
+	}
+	return s
+}
+
+// loadChosenCutlist loads the cutlist that has been chosen by the user (via
+// command line or interactively)
+func (v *video) loadChosenCutlist() (*cutlist, error) {
+	data, err := fetchCutlistFile(v.clID)
+	if err != nil {
+		return nil, fmt.Errorf("Cutlist ID=%s could not be fetched: %v", v.clID, err)
+	}
+	cl, err := parseCutlist(v.clID, data)
+	if err != nil {
+		return nil, err
+	}
+	cl.reason = fmt.Sprintf("Cutlist %s: chosen by user", v.clID)
+	// take author from the cutlist header if the cutlist doesn't contain it
+	for _, clh := range v.clhs {
+		if clh.id == v.clID && cl.author == "" {
+			cl.author = clh.author
+		}
+	}
+	return cl, nil
+}
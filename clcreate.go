@@ -0,0 +1,178 @@
+// Copyright (C) 2018 Michael Picht
+//
+// This file is part of gool (Online TV Recorder on Linux in Go).
+//
+// gool is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// gool is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with gool. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+// clcreate.go implements the creation of cutlists from ranges that are passed
+// via command line (sub command "cutlist create"), e.g.
+// "--keep 00:02:10-00:14:55 --keep 00:21:30-00:45:00". Ranges can be given as
+// times or as frame numbers ("#3250-#22375"). Times and frame numbers are
+// converted into each other with the frame rate of the decoded video. The
+// cutlist is written as local cutlist file, thus it's used for cutting in
+// the next run of "gool process".
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// decVideo returns a copy of the video that refers to its decoded file. If
+// the decoded file exists, its media information is determined
+func (v *video) decVideo() *video {
+	w := *v
+	w.filePath, w.status = v.decFilePath(), vidStatusDec
+	w.mi = nil
+	if exists(w.filePath) {
+		if err := w.probe(); err != nil {
+			log.WithFields(log.Fields{"key": v.key}).Warnf("Media information of %s cannot be determined: %v", w.filePath, err)
+		}
+	}
+	return &w
+}
+
+// newCutlist creates a (time based) cutlist for the decoded video with the
+// ranges rs (start and end in seconds) as segments
+func (v *video) newCutlist(rs [][2]float64, comment string) *cutlist {
+	cl := &cutlist{
+		app:       cfg.cutter,
+		applyTo:   filepath.Base(v.filePath),
+		origSize:  v.decSize(),
+		timeBased: true,
+		comment:   comment,
+	}
+	if v.mi != nil {
+		cl.fps = v.mi.fps
+	}
+	for _, r := range rs {
+		cl.segs = append(cl.segs, &seg{timeStart: r[0], timeDur: r[1] - r[0]})
+	}
+	return cl
+}
+
+// parseKeepRange parses a range that is passed via command line. It has the
+// format <start>-<end>. Start and end are either times (in seconds or in the
+// format HH:MM:SS.sss) or frame numbers ("#123"). The function returns start
+// and end in seconds and - if the range has been given as frame numbers - a
+// flag that is true
+func parseKeepRange(s string, fps float64) (float64, float64, bool, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return 0, 0, false, fmt.Errorf("Range '%s' doesn't have the format <start>-<end>", s)
+	}
+	start, end := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	if strings.HasPrefix(start, "#") != strings.HasPrefix(end, "#") {
+		return 0, 0, false, fmt.Errorf("Range '%s' mixes times and frame numbers", s)
+	}
+	tStart, err := parseEDLTime(start, fps)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("Range '%s': %v", s, err)
+	}
+	tEnd, err := parseEDLTime(end, fps)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("Range '%s': %v", s, err)
+	}
+	if tEnd <= tStart {
+		return 0, 0, false, fmt.Errorf("Range '%s': End must be after start", s)
+	}
+	return tStart, tEnd, strings.HasPrefix(start, "#"), nil
+}
+
+// createCutlist creates a cutlist for the video from the ranges keeps that
+// shall be kept
+func (v *video) createCutlist(keeps []string) (*cutlist, error) {
+	if len(keeps) == 0 {
+		return nil, fmt.Errorf("No ranges to keep have been passed")
+	}
+
+	w := v.decVideo()
+	var fps, dur float64
+	if w.mi != nil {
+		fps, dur = w.mi.fps, w.mi.dur
+	}
+
+	var (
+		rs     [][2]float64
+		frames = true
+	)
+	for _, k := range keeps {
+		start, end, isFrames, err := parseKeepRange(k, fps)
+		if err != nil {
+			return nil, err
+		}
+		if dur > 0 && end > dur {
+			return nil, fmt.Errorf("Range '%s' exceeds the end of the video (%s)", k, timeStr(dur))
+		}
+		frames = frames && isFrames
+		rs = append(rs, [2]float64{start, end})
+	}
+
+	// ranges must be in chronological order and must not overlap
+	sort.Slice(rs, func(i, j int) bool { return rs[i][0] < rs[j][0] })
+	for i := 1; i < len(rs); i++ {
+		if rs[i][0] < rs[i-1][1] {
+			return nil, fmt.Errorf("Ranges %s-%s and %s-%s overlap", timeStr(rs[i-1][0]), timeStr(rs[i-1][1]), timeStr(rs[i][0]), timeStr(rs[i][1]))
+		}
+	}
+
+	cl := w.newCutlist(rs, "Created with gool")
+	// if all ranges have been given as frame numbers, the cutlist is frame
+	// based as well
+	if frames {
+		cl.frameBased = true
+		for _, sg := range cl.segs {
+			sg.frameStart = int(math.Round(sg.timeStart * fps))
+			sg.frameDur = int(math.Round((sg.timeStart+sg.timeDur)*fps)) - sg.frameStart
+		}
+	}
+
+	return cl, nil
+}
+
+// createCutlistCmd implements the sub command "cutlist create": It creates a
+// cutlist for the video vid with the ranges keeps and writes it into the file
+// outFilePath (or the default path for local cutlists)
+func createCutlistCmd(vid string, keeps []string, outFilePath string) error {
+	vl := make(videoList)
+	if err := vl.read(nil); err != nil {
+		return err
+	}
+	v, err := vl.find(vid)
+	if err != nil {
+		return err
+	}
+
+	cl, err := v.createCutlist(keeps)
+	if err != nil {
+		return err
+	}
+
+	if outFilePath == "" {
+		outFilePath = v.localCutlistPath()
+	}
+	if err = writeCutlistFile(cl, outFilePath); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"key": v.key}).Infof("Cutlist %s has been created", outFilePath)
+	fmt.Printf("\nCutlist with %d segments (%s) has been written to %s\n", len(cl.segs), timeStr(cl.cutDur()), outFilePath)
+
+	return nil
+}
@@ -0,0 +1,284 @@
+// Copyright (C) 2018 Michael Picht
+//
+// This file is part of gool (Online TV Recorder on Linux in Go).
+//
+// gool is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// gool is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with gool. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+// clexport.go implements the export of cutlists into the formats of video
+// editors and other tools (sub command "cutlist export"). This allows to
+// fine-tune the cuts of an existing cutlist manually. Supported formats are
+// project scripts for Avidemux and VirtualDub, concat and metadata files for
+// FFmpeg as well as CSV and JSON.
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Constants for the export formats
+const (
+	clExpAvidemux   = "avidemux"   // Avidemux project script (tinyPy)
+	clExpVDub       = "vdub"       // VirtualDub script
+	clExpFFconcat   = "ffconcat"   // FFmpeg concat file (with in and out points)
+	clExpFFmetadata = "ffmetadata" // FFmpeg metadata file (with chapters)
+	clExpCSV        = "csv"        // comma separated list of segments
+	clExpJSON       = "json"       // cutlist and segments as JSON
+)
+
+// clExporters maps the export formats to the functions that create them
+var clExporters = map[string]func(*video, *cutlist) (string, error){
+	clExpAvidemux:   (*video).exportAvidemux,
+	clExpVDub:       (*video).exportVDub,
+	clExpFFconcat:   (*video).exportFFconcat,
+	clExpFFmetadata: (*video).exportFFmetadata,
+	clExpCSV:        (*video).exportCSV,
+	clExpJSON:       (*video).exportJSON,
+}
+
+// clExpFormats returns the supported export formats (sorted)
+func clExpFormats() []string {
+	var fs []string
+	for f := range clExporters {
+		fs = append(fs, f)
+	}
+	sort.Strings(fs)
+	return fs
+}
+
+// exportCutlist exports the cutlist cl of the video in the format format. The
+// result is written into the file outFilePath or - if it's empty - to stdout
+func (v *video) exportCutlist(cl *cutlist, format, outFilePath string) error {
+	exp, ok := clExporters[strings.ToLower(format)]
+	if !ok {
+		return fmt.Errorf("Export format '%s' is not supported. Use one of %s", format, strings.Join(clExpFormats(), ", "))
+	}
+
+	s, err := exp(v, cl)
+	if err != nil {
+		return err
+	}
+
+	if outFilePath == "" {
+		fmt.Print(s)
+		return nil
+	}
+	if err = ioutil.WriteFile(outFilePath, []byte(s), 0644); err != nil {
+		return fmt.Errorf("%s cannot be written: %v", outFilePath, err)
+	}
+	return nil
+}
+
+// exportAvidemux creates an Avidemux project script for the decoded video
+func (v *video) exportAvidemux(cl *cutlist) (string, error) {
+	w := *v
+	w.filePath = v.decFilePath()
+	w.cl = cl
+	return w.avidemuxScript(""), nil
+}
+
+// clFrames returns start frame and number of frames of the i-th segment of
+// the cutlist. If the cutlist doesn't contain frame numbers, they are
+// calculated from the times with the frame rate of the cutlist or - if it
+// doesn't have one - of the video
+func (v *video) clFrames(cl *cutlist, i int) (int, int, error) {
+	sg := cl.segs[i]
+	if !cl.timeBased || (sg.frameDur > 0 && cl.frameBased) {
+		return sg.frameStart, sg.frameDur, nil
+	}
+	fps := cl.fps
+	if fps == 0 {
+		if v.mi == nil && v.status != vidStatusEnc {
+			_ = v.probe()
+		}
+		if v.mi != nil {
+			fps = v.mi.fps
+		}
+	}
+	if fps == 0 {
+		return 0, 0, fmt.Errorf("Frame numbers cannot be determined: Frame rate is unknown")
+	}
+	start, end := cl.times(i)
+	fStart := int(math.Round(start * fps))
+	return fStart, int(math.Round(end*fps)) - fStart, nil
+}
+
+// exportVDub creates a VirtualDub script that loads the decoded video and
+// selects the segments of the cutlist
+func (v *video) exportVDub(cl *cutlist) (string, error) {
+	s := "// Generated by gool for " + v.key + "\n"
+	s += "VirtualDub.Open(" + strconv.Quote(v.decFilePath()) + ");\n"
+	s += "VirtualDub.subset.Clear();\n"
+	for i := range cl.segs {
+		start, n, err := v.clFrames(cl, i)
+		if err != nil {
+			return "", err
+		}
+		s += fmt.Sprintf("VirtualDub.subset.AddRange(%d, %d);\n", start, n)
+	}
+	s += "VirtualDub.video.SetMode(0);\n"
+	s += "VirtualDub.audio.SetMode(0);\n"
+	return s, nil
+}
+
+// exportFFconcat creates a concat file for FFmpeg that contains the segments
+// of the decoded video as in and out points. The video can be cut with
+// "ffmpeg -f concat -safe 0 -i <file> -c copy <output>"
+func (v *video) exportFFconcat(cl *cutlist) (string, error) {
+	s := "ffconcat version 1.0\n"
+	s += "# Generated by gool for " + v.key + "\n"
+	path := "'" + strings.Replace(v.decFilePath(), "'", `'\''`, -1) + "'"
+	for i := range cl.segs {
+		start, end := cl.times(i)
+		s += "file " + path + "\n"
+		s += "inpoint " + fmtSec(start) + "\n"
+		s += "outpoint " + fmtSec(end) + "\n"
+	}
+	return s, nil
+}
+
+// ffmetaEscape escapes the special characters of FFmpeg metadata files
+func ffmetaEscape(s string) string {
+	var b bytes.Buffer
+	for _, r := range s {
+		if strings.ContainsRune("=;#\\\n", r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// exportFFmetadata creates a metadata file for FFmpeg with the title of the
+// video and one chapter per segment (positions refer to the cut video)
+func (v *video) exportFFmetadata(cl *cutlist) (string, error) {
+	w := *v
+	w.cl = cl
+	s := ";FFMETADATA1\n"
+	s += "title=" + ffmetaEscape(w.mkvTitle()) + "\n"
+	ts := cl.mkvChapterTimes()
+	for i, t := range ts {
+		end := cl.cutDur()
+		if i+1 < len(ts) {
+			end = ts[i+1]
+		}
+		s += "\n[CHAPTER]\nTIMEBASE=1/1000\n"
+		s += fmt.Sprintf("START=%d\nEND=%d\n", int64(math.Round(t*1000)), int64(math.Round(end*1000)))
+		s += fmt.Sprintf("title=Part %d\n", i+1)
+	}
+	return s, nil
+}
+
+// exportCSV creates a CSV file with one line per segment
+func (v *video) exportCSV(cl *cutlist) (string, error) {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	_ = w.Write([]string{"segment", "start", "end", "duration", "start_frame", "frames"})
+	for i, sg := range cl.segs {
+		start, end := cl.times(i)
+		_ = w.Write([]string{
+			strconv.Itoa(i + 1),
+			fmtSec(start),
+			fmtSec(end),
+			fmtSec(end - start),
+			strconv.Itoa(sg.frameStart),
+			strconv.Itoa(sg.frameDur),
+		})
+	}
+	w.Flush()
+	return b.String(), w.Error()
+}
+
+// exportJSON exports the cutlist with its segments as JSON (same structure as
+// in the provenance sidecar)
+func (v *video) exportJSON(cl *cutlist) (string, error) {
+	data, err := json.MarshalIndent(v.provCutlist(cl), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
+
+// exportCutlistCmd implements the sub command "cutlist export": It determines
+// the cutlist of the video vid and exports it
+func exportCutlistCmd(vid, format, outFilePath, clID, clFile string) error {
+	v, cl, err := cutlistOfVideo(vid, clID, clFile)
+	if err != nil {
+		return err
+	}
+	return v.exportCutlist(cl, format, outFilePath)
+}
+
+// cutlistOfVideo reads the videos, looks up the video vid (a key or a file
+// name) and determines its cutlist: The cutlist with the ID clID or from the
+// file clFile (if one of them is not empty) or the current cutlist of the
+// video. The cutlist is prepared as for cutting. Thus, its segments are the
+// ones that are cut
+func cutlistOfVideo(vid, clID, clFile string) (*video, *cutlist, error) {
+	vl := make(videoList)
+	if err := vl.read(nil); err != nil {
+		return nil, nil, err
+	}
+	v, err := vl.find(vid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var cl *cutlist
+	if clFile != "" {
+		if _, err = os.Stat(clFile); err != nil {
+			return nil, nil, fmt.Errorf("Cutlist file %s cannot be read: %v", clFile, err)
+		}
+		if cl, err = readCutlistFile(clFile); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		v.clID = clID
+		if cl, err = v.currentCutlist(); err != nil {
+			return nil, nil, fmt.Errorf("No cutlist found for %s: %v", v.key, err)
+		}
+	}
+
+	if cl, err = v.preparedCutlist(cl); err != nil {
+		return nil, nil, err
+	}
+	return v, cl, nil
+}
+
+// preparedCutlist prepares the cutlist cl for the decoded video as it's done
+// before cutting (see prepareCutlist) and returns it. If the decoded video
+// doesn't exist (anymore), cl is returned unchanged
+func (v *video) preparedCutlist(cl *cutlist) (*cutlist, error) {
+	w := v.decVideo()
+	if !exists(w.filePath) {
+		log.WithFields(log.Fields{"key": v.key}).Warnf("Decoded video %s doesn't exist: Cutlist %s is not aligned and validated", w.filePath, cl.id)
+		return cl, nil
+	}
+	w.cl = cl
+	if err := w.prepareCutlist(); err != nil {
+		return nil, err
+	}
+	return w.cl, nil
+}
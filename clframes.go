@@ -0,0 +1,97 @@
+// Copyright (C) 2018 Michael Picht
+//
+// This file is part of gool (Online TV Recorder on Linux in Go).
+//
+// gool is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// gool is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with gool. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+// clframes.go decides whether the cuts of a cutlist are applied based on
+// frame numbers or on times. Frame numbers are only reliable if the cutting
+// program counts frames the same way as the program the cutlist has been
+// created with. That's the case for MPEG-4 ASP (DivX) AVIs (SD quality). For
+// H.264 videos (HQ and HD quality) and for MP4 containers it's not: Cutlist
+// programs like VirtualDub count the frames of the AVI index, whereas
+// MKVmerge counts the frames of the video track, which leads to cuts that
+// drift away over the course of the video. For such videos, the times are
+// used. If a cutlist only contains frame numbers, they are converted into
+// times with the frame rate of the cutlist (which is the frame rate the
+// frames have been counted with).
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Name of the cut application Avidemux (lower case, since the comparison is
+// case insensitive)
+const clAppAvidemux = "avidemux"
+
+// framesReliable checks if the frame numbers of the cutlist of the video can
+// be used for cutting
+func (v *video) framesReliable() bool {
+	// H.264 videos: frame numbers are not reliable
+	if v.mi != nil {
+		if v.mi.vCodec == "h264" || v.mi.vCodec == "hevc" {
+			return false
+		}
+	} else if v.ri.quality == vidQualityHQ || v.ri.quality == vidQualityHD {
+		return false
+	}
+
+	// frame numbers are only reliable for AVI containers
+	if v.ri.cf != "avi" {
+		return false
+	}
+
+	// frame numbers of Avidemux refer to the decoding order, which can differ
+	// from the presentation order. They are only reliable if Avidemux is used
+	// for cutting
+	if strings.Contains(strings.ToLower(v.cl.app), clAppAvidemux) && cfg.cutter != cutterAvidemux {
+		return false
+	}
+
+	return true
+}
+
+// decideCutMode decides whether the cuts of the cutlist of the video are
+// applied based on frame numbers or on times. If the frame numbers are not
+// reliable, the cutlist is converted to times (if it doesn't contain times)
+// and the frame numbers are ignored
+func (v *video) decideCutMode() {
+	cl := v.cl
+
+	// cutlist only contains times or frames can be used: nothing to do
+	if !cl.frameBased || v.framesReliable() {
+		return
+	}
+
+	// convert frame numbers into times if the cutlist doesn't contain times.
+	// If the cutlist doesn't contain a frame rate, the frame rate of the
+	// video is used
+	if !cl.timeBased {
+		if cl.fps == 0 && v.mi != nil {
+			cl.fps = v.mi.fps
+		}
+		if cl.fps == 0 {
+			log.WithFields(log.Fields{"key": v.key}).Warnf("Cutlist ID=%s: Frame numbers cannot be converted into times since the frame rate is unknown", cl.id)
+			return
+		}
+		cl.toTimes()
+		log.WithFields(log.Fields{"key": v.key}).Infof("Cutlist ID=%s (%s): Frame numbers have been converted into times with %.3f fps", cl.id, cl.app, cl.fps)
+	}
+	cl.frameBased = false
+	log.WithFields(log.Fields{"key": v.key}).Debugf("Cutlist ID=%s is applied based on times", cl.id)
+}
@@ -0,0 +1,101 @@
+// Copyright (C) 2018 Michael Picht
+//
+// This file is part of gool (Online TV Recorder on Linux in Go).
+//
+// gool is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// gool is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with gool. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+// clfuzzy.go implements the fuzzy lookup of cutlists. It's used if no cutlist
+// has been found for the key of a video. Often, cutlists only exist for
+// another version of the same broadcast. Thus, the cutlist server is asked
+// for (1) the other qualities of the broadcast, (2) the file name without
+// the TVOON suffix and (3) slightly shifted start times. The lookup stops
+// at the first stage that returns cutlists. All cutlists that are found that
+// way are labelled with clMatchFuzzy and a description of the variant.
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// start time shifts (in minutes) that are tolerated by the fuzzy lookup
+var clFuzzyShifts = [...]int{-5, 5, -10, 10}
+
+// clVariant is a variant of the key of a video that is used for the fuzzy lookup
+type clVariant struct {
+	name  string // name that is used to query the cutlist server
+	descr string // description of the variant (e.g. "quality HQ")
+}
+
+// fuzzyVariants returns the variants of the key of the video grouped by stage
+func (v *video) fuzzyVariants() [][]clVariant {
+	var (
+		qual  []clVariant
+		shift []clVariant
+	)
+
+	// stage 1: other qualities of the same broadcast
+	for _, q := range [...]string{vidQualitySD, vidQualityHQ, vidQualityHD, vidQualityMP4} {
+		if k := v.ri.key(q); k != v.key && q != v.ri.quality {
+			qual = append(qual, clVariant{name: k, descr: "quality " + q})
+		}
+	}
+
+	// stage 2: name without TVOON suffix (covers all qualities)
+	base := strings.TrimSuffix(v.ri.base(), otrSuffixTVOON)
+	stripped := []clVariant{{name: base, descr: "without " + strings.TrimPrefix(otrSuffixTVOON, "_")}}
+
+	// stage 3: shifted start times (without TVOON suffix to cover all qualities)
+	for _, m := range clFuzzyShifts {
+		ri := *v.ri
+		ri.start = ri.start.Add(time.Duration(m) * time.Minute)
+		shift = append(shift, clVariant{
+			name:  strings.TrimSuffix(ri.base(), otrSuffixTVOON),
+			descr: fmt.Sprintf("start %+d min", m),
+		})
+	}
+
+	return [][]clVariant{qual, stripped, shift}
+}
+
+// fuzzyCutlistHeaders looks up cutlists for variants of the key of the video.
+// The headers of the first stage that returns cutlists are returned.
+func (v *video) fuzzyCutlistHeaders() clHeaders {
+	if v.ri == nil {
+		return nil
+	}
+
+	for _, stage := range v.fuzzyVariants() {
+		var clhs clHeaders
+		for _, vr := range stage {
+			found := v.fetchCutlistHeaders("name="+vr.name, clMatchFuzzy)
+			for _, clh := range found {
+				clh.variant = vr.descr
+			}
+			clhs = clhs.merge(found)
+		}
+		if len(clhs) > 0 {
+			for _, clh := range clhs {
+				log.WithFields(log.Fields{"key": v.key}).Infof("Found cutlist ID=%s by fuzzy lookup (%s)", clh.id, clh.variant)
+			}
+			return clhs
+		}
+	}
+
+	return nil
+}
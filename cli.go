@@ -27,6 +27,7 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -49,7 +50,7 @@ var rootCmd = &cobra.Command{
 var cmdLst = &cobra.Command{
 	Use:   `list [files]`,
 	Short: `List videos`,
-	Long:  `List videos, incl. status ("ENC": encoded, "DEC": decoded but uncut, "CUT: cut). In addition, it's shown whether cutlists exist or not (column "CL"). Videos will not be processed.`,
+	Long:  `List videos, incl. status ("ENC": encoded, "DEC": decoded but uncut, "CUT": cut, "MRK": decoded and marked with skip files). In addition, it's shown whether cutlists exist or not (column "CL"). Videos will not be processed.`,
 	DisableFlagsInUseLine: true,
 	Args: cobra.ArbitraryArgs,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -66,6 +67,8 @@ var cmdLst = &cobra.Command{
 		}
 		// ... set the number of processes to be used by gool
 		_ = runtime.GOMAXPROCS(cfg.numCpus)
+		// switch on offline mode if requested via command line
+		cfg.offline = offline
 		// create video list
 		vl := make(videoList)
 		// read videos
@@ -73,6 +76,8 @@ var cmdLst = &cobra.Command{
 			fmt.Println(err.Error())
 			os.Exit(1)
 		}
+		// determine media information of decoded videos
+		vl.probe()
 		// print list of videos
 		vl.print()
 	},
@@ -99,6 +104,24 @@ var cmdPrc = &cobra.Command{
 		}
 		// ... set the number of processes to be used by gool
 		_ = runtime.GOMAXPROCS(cfg.numCpus)
+		// switch on offline mode if requested via command line
+		cfg.offline = offline
+		// overwrite configured cutter if one has been passed via command line
+		if cutterName != "" {
+			cfg.cutter = cutterName
+		}
+		// switch on accurate cutting if requested via command line
+		if accurateCut {
+			cfg.accurateCut = true
+		}
+		// switch on mark mode if requested via command line
+		if markOnly {
+			cfg.markOnly = true
+		}
+		if _, err := newCutter(cfg.cutter); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
 		// create video list
 		vl := make(videoList)
 		// read videos
@@ -106,6 +129,20 @@ var cmdPrc = &cobra.Command{
 			fmt.Println(err.Error())
 			os.Exit(1)
 		}
+		// assign cutlist files that have been passed via command line
+		if err := vl.assignCutlistFiles(clFiles); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		// assign cutlist IDs that have been passed via command line
+		if err := vl.assignCutlistIDs(clIDs); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		// let user choose cutlists if requested via command line
+		if choose {
+			vl.chooseCutlists()
+		}
 		// process videos
 		vl.process()
 		// print list of videos
@@ -113,21 +150,339 @@ var cmdPrc = &cobra.Command{
 	},
 }
 
+// sub command 'rename'
+var cmdRen = &cobra.Command{
+	Use:   `rename`,
+	Short: `Rename cut videos`,
+	Long:  `Rename the videos in the Cut directory according to the configured naming template. Each run is recorded in a journal, the last run can be reverted with --undo.`,
+	DisableFlagsInUseLine: true,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		// retrieve flags
+		_ = cmd.ParseFlags(args)
+		// set up logging
+		createLogger(logFile)
+		// print copyright etc. on command line
+		fmt.Println(preamble)
+		// Read configuration
+		if err := cfg.getFromFile(); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		// rename videos or undo last run
+		var err error
+		if undo {
+			err = undoRename()
+		} else {
+			err = rename(dryRun)
+		}
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+// sub command 'preview'
+var cmdPrv = &cobra.Command{
+	Use:   `preview <video>`,
+	Short: `Preview cutlist`,
+	Long:  `Play a few seconds around the start and the end of each segment of the cutlist of a video (key or file name) with mpv. The cutlist is the one that would be selected to cut the video or the one that is passed with --cutlist-id. Afterwards, the cutlist can be accepted or rejected. The decision is taken into account by the next run of 'gool process': An accepted cutlist is used to cut the video, a rejected cutlist is not used.`,
+	DisableFlagsInUseLine: true,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// retrieve flags
+		_ = cmd.ParseFlags(args)
+		// set up logging
+		createLogger(logFile)
+		// print copyright etc. on command line
+		fmt.Println(preamble)
+		// Read configuration
+		if err := cfg.getFromFile(); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		// switch on offline mode if requested via command line
+		cfg.offline = offline
+		// preview cutlist
+		if err := previewCmd(args[0], clID, prvWindow); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+// sub command 'cutlist'
+var cmdCL = &cobra.Command{
+	Use:   `cutlist [sub command]`,
+	Short: `Work with cutlists`,
+	Long:  `Work with cutlists of videos (e.g. create them, export them into the formats of video editors, import them from edit decision lists, upload and rate them).`,
+}
+
+// sub command 'cutlist export'
+var cmdCLExp = &cobra.Command{
+	Use:   `export <video>`,
+	Short: `Export cutlist`,
+	Long:  `Export the cutlist of a video (key or file name) into the format of a video editor or another tool. The cutlist is the one that has been used to cut the video or - if the video hasn't been cut yet - the one that would be selected. The result is written to stdout or into the file that is passed with --output.`,
+	DisableFlagsInUseLine: true,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// retrieve flags
+		_ = cmd.ParseFlags(args)
+		// set up logging
+		createLogger(logFile)
+		// messages go to stderr if the result is written to stdout
+		if outFile == "" {
+			msgOut = os.Stderr
+		}
+		// print copyright etc. on command line
+		fmt.Fprintln(msgOut, preamble)
+		// Read configuration
+		if err := cfg.getFromFile(); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		// switch on offline mode if requested via command line
+		cfg.offline = offline
+		// export cutlist
+		if err := exportCutlistCmd(args[0], expFormat, outFile, clID, clFile); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+// sub command 'cutlist import'
+var cmdCLImp = &cobra.Command{
+	Use:   `import <video> <edl file>`,
+	Short: `Import cutlist from EDL file`,
+	Long:  `Create a cutlist for a video (key or file name) from an edit decision list. Kodi EDL files (ranges to skip) and mpv EDL files (segments to play) are supported. The cutlist is written as local cutlist file into the cutlist directory (or next to the video) or into the file that is passed with --output. It's used when the video is processed the next time.`,
+	DisableFlagsInUseLine: true,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		// retrieve flags
+		_ = cmd.ParseFlags(args)
+		// set up logging
+		createLogger(logFile)
+		// print copyright etc. on command line
+		fmt.Println(preamble)
+		// Read configuration
+		if err := cfg.getFromFile(); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		// import EDL file
+		if err := importEDLCmd(args[0], args[1], outFile); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+// sub command 'cutlist create'
+var cmdCLCrt = &cobra.Command{
+	Use:   `create <video> --keep <start>-<end> ...`,
+	Short: `Create cutlist`,
+	Long:  `Create a cutlist for a video (key or file name) from the ranges that shall be kept. A range is passed with --keep (which can be repeated) and has the format <start>-<end>. Start and end are either times (HH:MM:SS.sss or seconds) or frame numbers (#<frame>). Times and frame numbers are converted with the frame rate of the decoded video. The cutlist is written as local cutlist file into the cutlist directory (or next to the video) or into the file that is passed with --output. It's used when the video is processed the next time.`,
+	DisableFlagsInUseLine: true,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// retrieve flags
+		_ = cmd.ParseFlags(args)
+		// set up logging
+		createLogger(logFile)
+		// print copyright etc. on command line
+		fmt.Println(preamble)
+		// Read configuration
+		if err := cfg.getFromFile(); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		// create cutlist
+		if err := createCutlistCmd(args[0], keeps, outFile); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+// sub command 'cutlist upload'
+var cmdCLUpl = &cobra.Command{
+	Use:   `upload <cutlist file>`,
+	Short: `Upload cutlist`,
+	Long:  `Upload a cutlist file to the cutlist server. The cutlist user ID must be set with the key 'user_id' in section [cutlist] of gool.conf.`,
+	DisableFlagsInUseLine: true,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// retrieve flags
+		_ = cmd.ParseFlags(args)
+		// set up logging
+		createLogger(logFile)
+		// print copyright etc. on command line
+		fmt.Println(preamble)
+		// Read configuration
+		if err := cfg.getFromFile(); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		// upload cutlist
+		msg, err := uploadCutlist(args[0])
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("\nCutlist server: %s\n", msg)
+	},
+}
+
+// sub command 'cutlist rate'
+var cmdCLRate = &cobra.Command{
+	Use:   `rate <cutlist id> <rating>`,
+	Short: `Rate cutlist`,
+	Long:  `Rate a cutlist on the cutlist server. The rating is a number between 0 (don't use this cutlist) and 5 (perfect). The cutlist user ID must be set with the key 'user_id' in section [cutlist] of gool.conf.`,
+	DisableFlagsInUseLine: true,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		// retrieve flags
+		_ = cmd.ParseFlags(args)
+		// set up logging
+		createLogger(logFile)
+		// print copyright etc. on command line
+		fmt.Println(preamble)
+		// Read configuration
+		if err := cfg.getFromFile(); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		// rate cutlist
+		msg, err := rateCutlist(args[0], args[1])
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("\nCutlist server: %s\n", msg)
+	},
+}
+
 // logFile stores parameter of logging flag
 var logFile string
 
+// cutterName stores parameter of cutter flag
+var cutterName string
+
+// accurateCut stores parameter of accurate flag
+var accurateCut bool
+
+// markOnly stores parameter of mark flag
+var markOnly bool
+
+// offline stores parameter of offline flag
+var offline bool
+
+// clFiles stores parameters of cutlist-file flag
+var clFiles []string
+
+// clIDs stores parameters of cutlist-id flag
+var clIDs []string
+
+// choose stores parameter of choose flag
+var choose bool
+
+// dryRun stores parameter of dry-run flag
+var dryRun bool
+
+// undo stores parameter of undo flag
+var undo bool
+
+// expFormat stores parameter of format flag
+var expFormat string
+
+// outFile stores parameter of output flag
+var outFile string
+
+// clID stores parameter of cutlist-id flag of the cutlist sub commands
+var clID string
+
+// clFile stores parameter of cutlist-file flag of the cutlist sub commands
+var clFile string
+
+// keeps stores parameters of keep flag
+var keeps []string
+
+// prvWindow stores parameter of seconds flag
+var prvWindow float64
+
 func init() {
 	// set custom help template
 	rootCmd.SetHelpTemplate(helpTemplate)
 	cmdLst.SetHelpTemplate(helpTemplate)
 	cmdPrc.SetHelpTemplate(helpTemplate)
+	cmdRen.SetHelpTemplate(helpTemplate)
+	cmdPrv.SetHelpTemplate(helpTemplate)
+	cmdCL.SetHelpTemplate(helpTemplate)
+	cmdCLExp.SetHelpTemplate(helpTemplate)
+	cmdCLImp.SetHelpTemplate(helpTemplate)
+	cmdCLCrt.SetHelpTemplate(helpTemplate)
+	cmdCLUpl.SetHelpTemplate(helpTemplate)
+	cmdCLRate.SetHelpTemplate(helpTemplate)
 
-	// build up command structure: 'list' and 'process' are sub commands of 'gool')
-	rootCmd.AddCommand(cmdLst, cmdPrc)
+	// build up command structure: 'list', 'process', 'rename', 'preview' and 'cutlist' are sub commands of 'gool')
+	rootCmd.AddCommand(cmdLst, cmdPrc, cmdRen, cmdPrv, cmdCL)
+	cmdCL.AddCommand(cmdCLExp, cmdCLImp, cmdCLCrt, cmdCLUpl, cmdCLRate)
 
 	// define flag for logging
 	cmdLst.Flags().StringVarP(&logFile, "log", "l", "", "Switch on logging and set log file name")
 	cmdPrc.Flags().StringVarP(&logFile, "log", "l", "", "Switch on logging and set log file name")
+	cmdRen.Flags().StringVarP(&logFile, "log", "l", "", "Switch on logging and set log file name")
+	cmdPrv.Flags().StringVarP(&logFile, "log", "l", "", "Switch on logging and set log file name")
+	cmdCLExp.Flags().StringVarP(&logFile, "log", "l", "", "Switch on logging and set log file name")
+	cmdCLImp.Flags().StringVarP(&logFile, "log", "l", "", "Switch on logging and set log file name")
+	cmdCLCrt.Flags().StringVarP(&logFile, "log", "l", "", "Switch on logging and set log file name")
+	cmdCLUpl.Flags().StringVarP(&logFile, "log", "l", "", "Switch on logging and set log file name")
+	cmdCLRate.Flags().StringVarP(&logFile, "log", "l", "", "Switch on logging and set log file name")
+
+	// define flags for the export of cutlists
+	cmdCLExp.Flags().StringVarP(&expFormat, "format", "f", clExpJSON, "Export format ("+strings.Join(clExpFormats(), ", ")+")")
+	cmdCLExp.Flags().StringVar(&outFile, "output", "", "Write result into this file instead of stdout")
+	cmdCLExp.Flags().StringVar(&clID, "cutlist-id", "", "ID of the cutlist to be exported")
+	cmdCLExp.Flags().StringVar(&clFile, "cutlist-file", "", "Cutlist file to be exported")
+	cmdCLExp.Flags().BoolVar(&offline, "offline", false, "Don't call the cutlist server. Use cached cutlists only")
+
+	// define flags for the import of cutlists
+	cmdCLImp.Flags().StringVar(&outFile, "output", "", "Write cutlist into this file")
+
+	// define flags for the creation of cutlists
+	cmdCLCrt.Flags().StringArrayVarP(&keeps, "keep", "k", nil, "Range to be kept (<start>-<end>). Can be repeated")
+	cmdCLCrt.Flags().StringVar(&outFile, "output", "", "Write cutlist into this file")
+
+	// define flags for the preview of cutlists
+	cmdPrv.Flags().StringVar(&clID, "cutlist-id", "", "ID of the cutlist to be previewed")
+	cmdPrv.Flags().Float64VarP(&prvWindow, "seconds", "s", previewWindowDefault, "Seconds that are played before and after each cut")
+	cmdPrv.Flags().BoolVar(&offline, "offline", false, "Don't call the cutlist server. Use cached cutlists only")
+
+	// define flags for renaming
+	cmdRen.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "Only show how the videos would be renamed")
+	cmdRen.Flags().BoolVar(&undo, "undo", false, "Revert the last rename run")
+
+	// define flag for offline mode
+	cmdLst.Flags().BoolVarP(&offline, "offline", "o", false, "Don't call the cutlist server. Use cached cutlists only")
+	cmdPrc.Flags().BoolVarP(&offline, "offline", "o", false, "Don't call the cutlist server. Use cached cutlists only")
+
+	// define flag for local cutlist files
+	cmdPrc.Flags().StringArrayVar(&clFiles, "cutlist-file", nil, "Cutlist file to be used ([video=]file). Can be repeated")
+
+	// define flags for manual selection of cutlists
+	cmdPrc.Flags().StringArrayVar(&clIDs, "cutlist-id", nil, "ID of the cutlist to be used ([video=]id). Can be repeated")
+	cmdPrc.Flags().BoolVar(&choose, "choose", false, "Choose cutlists interactively")
+
+	// define flag for cutter
+	cmdPrc.Flags().StringVarP(&cutterName, "cutter", "c", "", "Program to cut videos ("+cutterMKVmerge+", "+cutterFFmpeg+", "+cutterAvidemux+"). Overwrites the configured cutter")
+
+	// define flag for frame accurate cutting
+	cmdPrc.Flags().BoolVarP(&accurateCut, "accurate", "a", false, "Cut frame accurately with FFmpeg (re-encodes the video at the cut boundaries)")
+
+	// define flag for mark mode
+	cmdPrc.Flags().BoolVarP(&markOnly, "mark", "m", false, "Don't cut videos but write skip files (Kodi EDL, mpv EDL, chapters) next to the decoded videos")
 }
 
 // Execute executes the root command
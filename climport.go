@@ -0,0 +1,290 @@
+// Copyright (C) 2018 Michael Picht
+//
+// This file is part of gool (Online TV Recorder on Linux in Go).
+//
+// gool is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// gool is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with gool. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+// climport.go implements the import of edit decision lists (EDL) into cutlists
+// (sub command "cutlist import"). Two formats are supported:
+// - Kodi EDL files contain the ranges that shall be skipped (e.g. ad breaks).
+//   The segments of the cutlist are the ranges in between.
+// - mpv EDL files ("# mpv EDL v0") contain the segments that shall be played,
+//   i.e. the segments of the cutlist.
+// The result is written as local cutlist file. Thus, it's used for cutting
+// in the next run of "gool process".
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Kodi EDL actions that mark ranges to be skipped (0 = cut, 3 = commercial
+// break). Other actions (mute, scene marker) are ignored
+var edlSkipActions = map[int]bool{0: true, markKodiActionComm: true}
+
+// reEDLTime matches times in the format [[HH:]MM:]SS[.sss]
+var reEDLTime = regexp.MustCompile(`^(?:(?:(\d+):)?(\d+):)?(\d+(?:\.\d+)?)$`)
+
+// parseEDLTime parses a time of a Kodi EDL file or of a range that has been
+// passed via command line. It can be given in seconds, in the format
+// HH:MM:SS.sss or as frame number ("#123"). fps is needed to convert frame
+// numbers into seconds
+func parseEDLTime(s string, fps float64) (float64, error) {
+	if strings.HasPrefix(s, "#") {
+		n, err := strconv.Atoi(s[1:])
+		if err != nil {
+			return 0, fmt.Errorf("Frame number '%s' cannot be interpreted", s)
+		}
+		if fps == 0 {
+			return 0, fmt.Errorf("Frame number '%s' cannot be converted: Frame rate is unknown", s)
+		}
+		return float64(n) / fps, nil
+	}
+	m := reEDLTime.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("Time '%s' cannot be interpreted", s)
+	}
+	var t float64
+	for _, p := range m[1:3] {
+		n, _ := strconv.Atoi(p)
+		t = (t + float64(n)) * 60
+	}
+	sec, _ := strconv.ParseFloat(m[3], 64)
+	return t + sec, nil
+}
+
+// parseKodiEDL parses a Kodi EDL file and returns the ranges that shall be
+// skipped
+func parseKodiEDL(data []byte, fps float64) ([][2]float64, error) {
+	var rs [][2]float64
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "//") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("Line %d of EDL file doesn't contain start and end", n)
+		}
+		start, err := parseEDLTime(fields[0], fps)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d of EDL file: %v", n, err)
+		}
+		end, err := parseEDLTime(fields[1], fps)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d of EDL file: %v", n, err)
+		}
+		// the action is optional. Default is 0 (cut)
+		action := 0
+		if len(fields) > 2 {
+			if action, err = strconv.Atoi(fields[2]); err != nil {
+				return nil, fmt.Errorf("Line %d of EDL file: Action '%s' cannot be interpreted", n, fields[2])
+			}
+		}
+		if !edlSkipActions[action] || end <= start {
+			continue
+		}
+		rs = append(rs, [2]float64{start, end})
+	}
+
+	return rs, sc.Err()
+}
+
+// names of the positional parameters of mpv EDL entries
+var mpvEDLParams = []string{"file", "start", "length"}
+
+// splitMPVEDLParams splits one line of an mpv EDL file into its parameters.
+// Parameters are separated by commas. Values can have the format "%n%value"
+// (length prefix), in that case they can contain commas
+func splitMPVEDLParams(line string) []string {
+	var ps []string
+	for line != "" {
+		var p string
+		// named parameters: the length prefix follows the "="
+		prefix := ""
+		if i := strings.IndexAny(line, "=,%"); i >= 0 && line[i] == '=' {
+			prefix, line = line[:i+1], line[i+1:]
+		}
+		if strings.HasPrefix(line, "%") {
+			if j := strings.Index(line[1:], "%"); j >= 0 {
+				if n, err := strconv.Atoi(line[1 : j+1]); err == nil && j+2+n <= len(line) {
+					p, line = line[j+2:j+2+n], line[j+2+n:]
+					ps = append(ps, prefix+p)
+					line = strings.TrimPrefix(line, ",")
+					continue
+				}
+			}
+		}
+		if i := strings.Index(line, ","); i >= 0 {
+			p, line = line[:i], line[i+1:]
+		} else {
+			p, line = line, ""
+		}
+		ps = append(ps, prefix+p)
+	}
+	return ps
+}
+
+// parseMPVEDL parses an mpv EDL file and returns the segments that shall be
+// played. Segments without length last until the end of the video (dur)
+func parseMPVEDL(data []byte, dur float64) ([][2]float64, error) {
+	var rs [][2]float64
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		// skip comments, header lines (e.g. "!new_stream") and empty lines
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+		var (
+			start, length float64
+			hasLen        bool
+			err           error
+		)
+		for i, p := range splitMPVEDLParams(line) {
+			name, val := "", p
+			if j := strings.Index(p, "="); j >= 0 {
+				name, val = p[:j], p[j+1:]
+			} else if i < len(mpvEDLParams) {
+				name = mpvEDLParams[i]
+			}
+			switch name {
+			case "start":
+				start, err = strconv.ParseFloat(val, 64)
+			case "length":
+				length, err = strconv.ParseFloat(val, 64)
+				hasLen = true
+			}
+			if err != nil {
+				return nil, fmt.Errorf("Line %d of EDL file: %s '%s' cannot be interpreted", n, name, val)
+			}
+		}
+		if !hasLen {
+			if dur == 0 {
+				return nil, fmt.Errorf("Line %d of EDL file: Segment has no length and the duration of the video is unknown", n)
+			}
+			length = dur - start
+		}
+		if length > 0 {
+			rs = append(rs, [2]float64{start, start + length})
+		}
+	}
+
+	return rs, sc.Err()
+}
+
+// keepRanges returns the ranges of the video (with duration dur) that are not
+// covered by the skip ranges rs
+func keepRanges(rs [][2]float64, dur float64) ([][2]float64, error) {
+	var (
+		keep [][2]float64
+		pos  float64
+	)
+	// ranges are processed in chronological order
+	sorted := append([][2]float64(nil), rs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i][0] < sorted[j][0] })
+	for _, r := range sorted {
+		if r[0] > pos {
+			keep = append(keep, [2]float64{pos, r[0]})
+		}
+		if r[1] > pos {
+			pos = r[1]
+		}
+	}
+	// range after the last skip range
+	if dur == 0 {
+		return nil, fmt.Errorf("Duration of the video is unknown: The end of the last segment cannot be determined")
+	}
+	if dur > pos {
+		keep = append(keep, [2]float64{pos, dur})
+	}
+	return keep, nil
+}
+
+// importEDL creates a cutlist for the video from the EDL file edlPath
+func (v *video) importEDL(edlPath string) (*cutlist, error) {
+	data, err := ioutil.ReadFile(edlPath)
+	if err != nil {
+		return nil, fmt.Errorf("EDL file %s cannot be read: %v", edlPath, err)
+	}
+
+	// media information of the decoded video is needed for frame numbers and
+	// the duration
+	w := v.decVideo()
+	var fps, dur float64
+	if w.mi != nil {
+		fps, dur = w.mi.fps, w.mi.dur
+	}
+
+	// parse EDL file
+	var rs [][2]float64
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(markMPVHeader)) {
+		rs, err = parseMPVEDL(data, dur)
+	} else {
+		if rs, err = parseKodiEDL(data, fps); err == nil {
+			rs, err = keepRanges(rs, dur)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rs) == 0 {
+		return nil, fmt.Errorf("EDL file %s doesn't contain any segment", edlPath)
+	}
+
+	return w.newCutlist(rs, "Imported from "+filepath.Base(edlPath)), nil
+}
+
+// importEDLCmd implements the sub command "cutlist import": It creates a
+// cutlist for the video vid from the EDL file edlPath and writes it into
+// the file outFilePath (or the default path for local cutlists)
+func importEDLCmd(vid, edlPath, outFilePath string) error {
+	vl := make(videoList)
+	if err := vl.read(nil); err != nil {
+		return err
+	}
+	v, err := vl.find(vid)
+	if err != nil {
+		return err
+	}
+
+	cl, err := v.importEDL(edlPath)
+	if err != nil {
+		return err
+	}
+
+	if outFilePath == "" {
+		outFilePath = v.localCutlistPath()
+	}
+	if err = writeCutlistFile(cl, outFilePath); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"key": v.key}).Infof("EDL file %s has been imported into %s", edlPath, outFilePath)
+	fmt.Printf("\nCutlist with %d segments has been written to %s\n", len(cl.segs), outFilePath)
+
+	return nil
+}
@@ -0,0 +1,181 @@
+// Copyright (C) 2018 Michael Picht
+//
+// This file is part of gool (Online TV Recorder on Linux in Go).
+//
+// gool is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// gool is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with gool. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+// clpolicy.go implements the policy that is used to select a cutlist from the
+// cutlists that the cutlist server offers for a video. The policy is configured
+// in the section [cutlist] of gool.conf. Cutlists of blocked authors and
+// cutlists that are flagged with errors are rejected. Cutlists of trusted
+// authors are always accepted and preferred. All other cutlists must have a
+// minimum rating and a minimum number of ratings.
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Constants for the error flags of cutlists
+const (
+	clErrEPG              = "EPGError"
+	clErrMissingBeginning = "MissingBeginning"
+	clErrMissingEnding    = "MissingEnding"
+	clErrMissingVideo     = "MissingVideo"
+	clErrMissingAudio     = "MissingAudio"
+	clErrOther            = "OtherError"
+)
+
+// clErrFlags contains the error flags in the order of the bits of the element
+// "errors" of the cutlist headers (e.g. "000100" means "MissingAudio")
+var clErrFlags = [...]string{clErrEPG, clErrMissingBeginning, clErrMissingEnding, clErrMissingVideo, clErrMissingAudio, clErrOther}
+
+// Constants for how a cutlist has been found
+const (
+	clMatchSize  = "file size" // cutlist has been found by the size of the decoded file
+	clMatchName  = "name"      // cutlist has been found by the key of the video
+	clMatchNone  = "none"      // cutlist has been created for another file
+	clMatchFuzzy = "fuzzy"     // cutlist has been found for a variant of the key
+)
+
+// Bonuses that are added to the score of cutlists. Cutlists that have been
+// found by file size are ranked before all other cutlists, since they have
+// been created for exactly that file. Cutlists of trusted authors are ranked
+// before the cutlists of other authors. Cutlists that have been found by
+// fuzzy lookup are ranked after all others
+const (
+	clSizeBonus    = 200
+	clTrustedBonus = 100
+	clFuzzyMalus   = 300
+)
+
+// clPolicy contains the configured rules for the selection of cutlists
+type clPolicy struct {
+	minRating      float64             // minimum rating of a cutlist
+	minRatingCount int                 // minimum number of ratings of a cutlist
+	trusted        map[string]struct{} // trusted authors (lower case)
+	blocked        map[string]struct{} // blocked authors (lower case)
+	rejectErrors   bool                // reject cutlists that are flagged with errors
+}
+
+// authorSet converts a comma separated list of authors into a set. The
+// authors are stored in lower case, since the comparison is case insensitive
+func authorSet(s string) map[string]struct{} {
+	set := make(map[string]struct{})
+	for _, a := range strings.Split(s, ",") {
+		if a = strings.ToLower(strings.TrimSpace(a)); a != "" {
+			set[a] = struct{}{}
+		}
+	}
+	return set
+}
+
+// clErrors determines the error flags of a cutlist. errBits is the content of
+// the element "errors" of the cutlist header (a string of 0s and 1s). explicit
+// maps error flags to the content of the dedicated elements (e.g. "EPGError")
+// that some cutlist servers provide in addition. A flag is set if it's set in
+// either of them
+func clErrors(errBits string, explicit map[string]string) []string {
+	var errs []string
+	for i, flag := range clErrFlags {
+		set := i < len(errBits) && errBits[i] == '1'
+		if v := strings.TrimSpace(explicit[flag]); v != "" && v != "0" {
+			set = true
+		}
+		if set {
+			errs = append(errs, flag)
+		}
+	}
+	return errs
+}
+
+// effRating returns the rating that is used for scoring a cutlist: The rating
+// of the users or - if there are no ratings yet - the rating of the author
+func (clh *clHeader) effRating() float64 {
+	if clh.ratingCount == 0 {
+		return clh.ratingByAuthor
+	}
+	return clh.rating
+}
+
+// apply scores the cutlist headers according to the policy. Headers that
+// don't comply with the policy are flagged as rejected. For each header, the
+// reason for the decision is stored
+func (p *clPolicy) apply(clhs clHeaders) {
+	for _, clh := range clhs {
+		author := strings.ToLower(clh.author)
+		_, trusted := p.trusted[author]
+		_, blocked := p.blocked[author]
+
+		clh.score = clh.effRating()
+		switch clh.match {
+		case clMatchSize:
+			clh.score += clSizeBonus
+		case clMatchFuzzy:
+			clh.score -= clFuzzyMalus
+		}
+		clh.rejected = true
+		switch {
+		case clh.match == clMatchNone:
+			clh.reason = fmt.Sprintf("created for %s", clh.name)
+		case blocked:
+			clh.reason = fmt.Sprintf("author %s is blocked", clh.author)
+		case p.rejectErrors && len(clh.errors) > 0:
+			clh.reason = fmt.Sprintf("flagged with %s", strings.Join(clh.errors, ", "))
+		case trusted:
+			clh.score += clTrustedBonus
+			clh.rejected = false
+			clh.reason = fmt.Sprintf("author %s is trusted", clh.author)
+		case clh.effRating() < p.minRating:
+			clh.reason = fmt.Sprintf("rating %.2f is below %.2f", clh.effRating(), p.minRating)
+		case clh.ratingCount < p.minRatingCount:
+			clh.reason = fmt.Sprintf("%d ratings are less than %d", clh.ratingCount, p.minRatingCount)
+		default:
+			clh.rejected = false
+			clh.reason = "best score"
+		}
+	}
+}
+
+// string returns a description of the cutlist header incl. the reason why it
+// has been picked or rejected
+func (clh *clHeader) string() string {
+	match := clh.match
+	if clh.variant != "" {
+		match += " - " + clh.variant
+	}
+	return fmt.Sprintf("Cutlist %s by %s (rating %.2f, %d ratings, match: %s): %s", clh.id, clh.author, clh.effRating(), clh.ratingCount, match, clh.reason)
+}
+
+// clReason returns why a cutlist has been picked for the video. If no
+// cutlist has been picked, the reasons why the cutlists of the cutlist server
+// have been rejected are returned. If no cutlist has been considered at all,
+// an empty string is returned
+func (v *video) clReason() string {
+	if v.cl != nil {
+		return v.cl.reason
+	}
+	var rs []string
+	for _, clh := range v.clhs {
+		if clh.rejected {
+			rs = append(rs, clh.string())
+		}
+	}
+	if len(rs) == 0 {
+		return ""
+	}
+	return "No cutlist picked. Rejected:\n    " + strings.Join(rs, "\n    ")
+}
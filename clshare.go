@@ -0,0 +1,147 @@
+// Copyright (C) 2018 Michael Picht
+//
+// This file is part of gool (Online TV Recorder on Linux in Go).
+//
+// gool is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// gool is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with gool. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+// clshare.go implements the upload of cutlist files to the cutlist server
+// (sub command "cutlist upload") and the rating of cutlists (sub command
+// "cutlist rate"). Both require the cutlist user ID that has to be set with
+// the key "user_id" in section [cutlist] of gool.conf. The message that the
+// cutlist server returns is shown to the user.
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Constants for the calls of the cutlist server
+const (
+	clsUploadPath   = "index.php?upload=2" // upload of cutlist files
+	clsUploadField  = "userfile[]"         // form field for the cutlist file
+	clsRatePath     = "rate.php"           // rating of cutlists
+	clsRatingMax    = 5                    // highest rating
+	clsUserIDMissed = "No cutlist user ID configured. Set it with the key '" + cfgKeyCLUserID + "' in section [" + cfgSectionCL + "] of gool.conf"
+)
+
+// reHTMLTag matches HTML tags
+var reHTMLTag = regexp.MustCompile(`<[^>]*>`)
+
+// clsMessage extracts the message from the response of the cutlist server:
+// HTML tags are removed and white space is condensed
+func clsMessage(data []byte) string {
+	s := reHTMLTag.ReplaceAllString(string(data), " ")
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// httpPost sends the body with the given content type to the URL and
+// returns the body of the response
+func httpPost(u, contentType string, body []byte) ([]byte, error) {
+	resp, err := http.Post(u, contentType, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %s", u, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// uploadCutlist uploads the cutlist file filePath to the cutlist server and
+// returns the message of the server
+func uploadCutlist(filePath string) (string, error) {
+	if cfg.clUserID == "" {
+		return "", fmt.Errorf(clsUserIDMissed)
+	}
+
+	// only valid cutlists are uploaded
+	cl, err := readCutlistFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	if len(cl.segs) == 0 {
+		return "", fmt.Errorf("Cutlist file %s doesn't contain any segment", filePath)
+	}
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return "", fmt.Errorf("Cutlist file %s cannot be read: %v", filePath, err)
+	}
+
+	// create multipart form with user ID and cutlist file
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	_ = w.WriteField("userid", cfg.clUserID)
+	_ = w.WriteField("version", "gool "+Version)
+	fw, err := w.CreateFormFile(clsUploadField, filepath.Base(filePath))
+	if err != nil {
+		return "", err
+	}
+	if _, err = fw.Write(data); err != nil {
+		return "", err
+	}
+	if err = w.Close(); err != nil {
+		return "", err
+	}
+
+	log.Debugf("Call cutlist server: %s%s (file %s)", cfg.clsURL, clsUploadPath, filePath)
+	resp, err := httpPost(cfg.clsURL+clsUploadPath, w.FormDataContentType(), body.Bytes())
+	if err != nil {
+		return "", fmt.Errorf("Cutlist file %s cannot be uploaded: %v", filePath, err)
+	}
+	msg := clsMessage(resp)
+	log.Infof("Cutlist file %s has been uploaded: %s", filePath, msg)
+	return msg, nil
+}
+
+// rateCutlist rates the cutlist with the ID id on the cutlist server and
+// returns the message of the server. score must be between 0 and 5
+func rateCutlist(id, score string) (string, error) {
+	if cfg.clUserID == "" {
+		return "", fmt.Errorf(clsUserIDMissed)
+	}
+	if _, err := strconv.Atoi(id); err != nil {
+		return "", fmt.Errorf("'%s' is not a valid cutlist ID", id)
+	}
+	if n, err := strconv.Atoi(score); err != nil || n < 0 || n > clsRatingMax {
+		return "", fmt.Errorf("Rating '%s' is not valid: It must be a number between 0 and %d", score, clsRatingMax)
+	}
+
+	query := url.Values{}
+	query.Set("rate", id)
+	query.Set("rating", score)
+	query.Set("userid", cfg.clUserID)
+	query.Set("version", "gool "+Version)
+
+	log.Debugf("Call cutlist server: %s%s?rate=%s&rating=%s", cfg.clsURL, clsRatePath, id, score)
+	resp, err := httpGet(cfg.clsURL + clsRatePath + "?" + query.Encode())
+	if err != nil {
+		return "", fmt.Errorf("Cutlist ID=%s cannot be rated: %v", id, err)
+	}
+	msg := clsMessage(resp)
+	log.Infof("Cutlist ID=%s has been rated with %s: %s", id, score, msg)
+	return msg, nil
+}
@@ -18,7 +18,9 @@
 package main
 
 // cut.go implements the call of command line tools to cut a video based on
-// a cutlist is implemented. Currently, only FFmpeg is used.
+// a cutlist. The tools are abstracted by the interface cutter. Currently,
+// MKVmerge, FFmpeg and Avidemux are supported. In addition, FFmpeg can be
+// used for frame accurate cutting.
 
 import (
 	"bufio"
@@ -30,58 +32,61 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
-// callMKVmerge calls mkvmerge and handles the command line output. It return
-// the container format in case the container format has changed (otherwise "")
-func (v *video) callMKVmerge() (string, error) {
-	var (
-		err         error
-		errStr      string
-		splitStr    string
-		outFilePath string
-		stderr      io.ReadCloser
-	)
-
-	// create stop channel for progress bar
-	stop := make(chan struct{})
+// Constants for the AC3 audio track in the cut video
+const (
+	ac3Language  = "ger"           // language of AC3 track (OTR records German TV only)
+	ac3TrackName = "Dolby Digital" // name of AC3 track
+)
 
-	// start automatic progress bar which increments every 0.5s
-	go v.autoIncr(prgActCut, 500, stop)
+// Constants for the names of the supported cutters
+const (
+	cutterMKVmerge = "mkvmerge"
+	cutterFFmpeg   = "ffmpeg"
+	cutterAvidemux = "avidemux"
+)
 
-	// stop progress bar once fetchCutlists finalizes
-	defer func() { stop <- struct{}{} }()
+// cutter abstracts the command line tools that can be used to cut a video
+// based on its cutlist
+type cutter interface {
+	// cut cuts the video v according to its cutlist and stores the result in
+	// outFilePath. It returns the container format of the cut video
+	cut(v *video, outFilePath string) (string, error)
+}
 
-	// create split string for MKVmerge
-	if v.cl.frameBased {
-		splitStr = "parts-frames:"
-		for i := 0; i < len(v.cl.segs); i++ {
-			if i > 0 {
-				splitStr += ",+"
-			}
-			splitStr += strconv.Itoa(v.cl.segs[i].frameStart) + "-" + strconv.Itoa(v.cl.segs[i].frameStart+v.cl.segs[i].frameDur)
-		}
-	} else {
-		splitStr = "parts:"
-		for i := 0; i < len(v.cl.segs); i++ {
-			if i > 0 {
-				splitStr += ",+"
-			}
-			splitStr += timeStr(v.cl.segs[i].timeStart) + "-" + timeStr(v.cl.segs[i].timeStart+v.cl.segs[i].timeDur)
-		}
+// newCutter returns the cutter with the given name. If there is no cutter
+// with that name, an error is returned
+func newCutter(name string) (cutter, error) {
+	switch strings.ToLower(name) {
+	case cutterMKVmerge:
+		return mkvmergeCutter{}, nil
+	case cutterFFmpeg:
+		return ffmpegCutter{}, nil
+	case cutterAvidemux:
+		return avidemuxCutter{}, nil
 	}
+	return nil, fmt.Errorf("Cutter '%s' is not supported. Use one of %s, %s, %s", name, cutterMKVmerge, cutterFFmpeg, cutterAvidemux)
+}
 
-	// set path of output file
-	outFilePath = cfg.cutDirPath + "/" + v.key + ".cut.mkv"
-
-	// Create shell command for decoding
-	cmd := exec.Command("mkvmerge",
-		"-o", outFilePath,
-		"--split", splitStr,
-		v.filePath,
+// execCut executes a command line tool that is used for cutting and handles
+// its output: Each line of stdout is passed to the function prg (if it's not
+// nil) to update the progress. In case of an error, the output of the tool is
+// written into the cut error file of the video
+func (v *video) execCut(prg func(string), name string, args ...string) error {
+	var (
+		err    error
+		errStr string
+		outStr string
+		stdout io.ReadCloser
+		stderr io.ReadCloser
 	)
+
+	// Create shell command
+	cmd := exec.Command(name, args...)
 	// print cmd string to log
 	{
 		s := ""
@@ -90,42 +95,66 @@ func (v *video) callMKVmerge() (string, error) {
 		}
 		log.WithFields(log.Fields{"key": v.key}).Debugf("Cut command: %s", s)
 	}
+	// Set up output pipe
+	stdout, err = cmd.StdoutPipe()
+	if err != nil {
+		log.WithFields(log.Fields{"key": v.key}).Errorf("Cannot establish pipe for stdout: %v", err.Error())
+		return err
+	}
 	// Set up error pipe
 	stderr, err = cmd.StderrPipe()
 	if err != nil {
 		log.WithFields(log.Fields{"key": v.key}).Errorf("Cannot establish pipe for stderr: %v", err.Error())
-		return "", err
+		return err
 	}
 	// Start the command after having set up the pipes
 	if err = cmd.Start(); err != nil {
-		log.WithFields(log.Fields{"key": v.key}).Errorf("Cannot start MKVmerge: %v", err.Error())
-		return "", err
+		log.WithFields(log.Fields{"key": v.key}).Errorf("Cannot start %s: %v", name, err.Error())
+		return err
 	}
-	log.WithFields(log.Fields{"key": v.key}).Infof("Video has been cut with MKVmerge: %s", outFilePath)
 
-	// read command's stderr line by line and store it in a errStr for further processing
-	cmdErr := bufio.NewScanner(stderr)
-	for cmdErr.Scan() {
-		errStr += fmt.Sprintf("%s\n", cmdErr.Text())
+	// read command's stderr line by line in a separate go routine and store
+	// it in errStr for further processing
+	done := make(chan struct{})
+	go func() {
+		cmdErr := bufio.NewScanner(stderr)
+		for cmdErr.Scan() {
+			errStr += fmt.Sprintf("%s\n", cmdErr.Text())
+		}
+		close(done)
+	}()
+
+	// read command's stdout line by line, update progress and store it in
+	// outStr (some tools - like MKVmerge - write their errors to stdout)
+	cmdOut := bufio.NewScanner(stdout)
+	for cmdOut.Scan() {
+		if prg != nil {
+			prg(cmdOut.Text())
+		}
+		outStr += fmt.Sprintf("%s\n", cmdOut.Text())
 	}
+	<-done
+
 	if err = cmd.Wait(); err != nil {
-		// In case command line execution returns error, content of stderr (now contained in
-		// errStr) is written into error file
-		errFilePath := cfg.logDirPath + "/" + v.key + path.Ext(v.filePath) + errFileSuffixCut
-		if errFile, e := os.Create(errFilePath); e != nil {
-			log.WithFields(log.Fields{"key": v.key}).Errorf("Cannot create \"%s\": %v", errFilePath, e)
-		} else {
-			if _, e = errFile.WriteString(errStr); e != nil {
-				log.WithFields(log.Fields{"key": v.key}).Errorf("Cannot write into \"%s\": %v", errFilePath, e)
-			}
-			_ = errFile.Close()
-		}
+		// In case command line execution returns error, the output (now contained
+		// in errStr and outStr) is written into error file
+		v.writeCutErrFile(errStr + outStr)
 	}
 
-	// set progress to 100%
-	v.setPrgBar(prgActCut, 100)
+	return err
+}
 
-	return "mkv", err
+// writeCutErrFile writes errStr into the cut error file of the video
+func (v *video) writeCutErrFile(errStr string) {
+	errFilePath := cfg.logDirPath + "/" + v.key + path.Ext(v.filePath) + errFileSuffixCut
+	if errFile, e := os.Create(errFilePath); e != nil {
+		log.WithFields(log.Fields{"key": v.key}).Errorf("Cannot create \"%s\": %v", errFilePath, e)
+	} else {
+		if _, e = errFile.WriteString(errStr); e != nil {
+			log.WithFields(log.Fields{"key": v.key}).Errorf("Cannot write into \"%s\": %v", errFilePath, e)
+		}
+		_ = errFile.Close()
+	}
 }
 
 // cut cuts a video according to it's cutlist. The method is called as go
@@ -151,18 +180,140 @@ func (v *video) cut(wg *sync.WaitGroup, r <-chan res) {
 		return
 	}
 
+	// the cutlist might have been loaded before the video has been decoded.
+	// Now, the size of the decoded file is known. Thus, cutlists from the
+	// cutlist server are checked again. If the cutlist doesn't fit, another
+	// one is loaded. Cutlists that have been chosen by the user are not checked
+	v.size = v.decSize()
+	if v.cl.src == "" && v.clID == "" {
+		if err := v.checkCutlist(v.cl); err != nil {
+			log.WithFields(log.Fields{"key": v.key}).Warnf("Cutlist ID=%s is not used: %v", v.cl.id, err)
+			if v.cl = v.loadCutlistDetails(v.loadCutlistHeaders()); v.cl == nil {
+				log.WithFields(log.Fields{"key": v.key}).Error("No fitting cutlist found")
+				v.writeCutErrFile("No fitting cutlist found\n")
+				v.res = vidResultErr
+				return
+			}
+		}
+	}
+
+	// align and validate the cutlist
+	if err := v.prepareCutlist(); err != nil {
+		log.WithFields(log.Fields{"key": v.key}).Error(err.Error())
+		v.writeCutErrFile(err.Error() + "\n")
+		v.res = vidResultErr
+		return
+	}
+
 	// clean up stuff from former processing runs
 	if err := v.preProcessing(); err != nil {
 		return
 	}
 
-	// call MKVmerge to cut the video
-	cf, errCut := v.callMKVmerge()
+	// in mark mode, skip files are written instead of cutting the video
+	if cfg.markOnly {
+		v.markVideo()
+		return
+	}
+
+	// call the configured cutter to cut the video
+	decFilePath := v.filePath
+	v.stats.decName = path.Base(v.filePath)
+	v.stats.decSize = v.size
+	start := time.Now()
+	cf, errCut := v.callCutter()
+	v.stats.cutDur = time.Since(start)
 
 	// Process videos based on error info from decoding go routine
 	if err := v.postProcessing(cf, errCut); err != nil {
 		log.WithFields(log.Fields{"key": v.key}).Error(err.Error())
 	}
+
+	// remove skip files (if the video has been marked before)
+	if errCut == nil {
+		v.unmark(decFilePath)
+	}
+
+	// export cut video into the media server library
+	if errCut == nil && cfg.libRootPath != "" {
+		if err := v.exportToLibrary(); err != nil {
+			log.WithFields(log.Fields{"key": v.key}).Error(err.Error())
+			v.writeCutErrFile(err.Error() + "\n")
+			v.res = vidResultErr
+		}
+	}
+
+	// store record of the cut video
+	if errCut == nil {
+		v.writeCutRec()
+		if cfg.provenance {
+			v.writeProvenance()
+		}
+	}
+}
+
+// prepareCutlist prepares the cutlist of the video for cutting: It's aligned
+// if it has been created for another version of the broadcast and validated
+// against the media information of the video. Finally, it's decided whether
+// the cuts are based on frame numbers or on times
+func (v *video) prepareCutlist() error {
+	if err := v.alignCutlist(); err != nil {
+		return err
+	}
+	if err := v.validateCutlist(); err != nil {
+		return err
+	}
+	v.decideCutMode()
+	return nil
+}
+
+// callCutter cuts the video with the configured cutter. It returns the
+// container format of the cut video
+func (v *video) callCutter() (string, error) {
+	var (
+		c   cutter
+		cf  string
+		err error
+	)
+
+	// set progress to 100% once cutting finalizes
+	defer v.setPrgBar(prgActCut, 100)
+
+	// get cutter: In accurate mode, FFmpeg is used with re-encoding at the
+	// cut boundaries. Otherwise, the configured cutter is used
+	name := cfg.cutter
+	v.stats.cutProg = cutterProgs[strings.ToLower(cfg.cutter)]
+	if cfg.accurateCut {
+		c = accurateCutter{}
+		name = cutterFFmpeg + " (accurate)"
+		v.stats.cutProg = ffmpegName
+	} else {
+		if c, err = newCutter(cfg.cutter); err != nil {
+			log.WithFields(log.Fields{"key": v.key}).Error(err.Error())
+			v.writeCutErrFile(err.Error() + "\n")
+			return "", err
+		}
+	}
+
+	// set path of output file based on the naming template
+	outFilePath, err := reservePath(cfg.cutDirPath, v.cutFileName(), ".mkv")
+	if err != nil {
+		log.WithFields(log.Fields{"key": v.key}).Error(err.Error())
+		v.writeCutErrFile(err.Error() + "\n")
+		return "", err
+	}
+
+	// cut video
+	v.stats.cutter = name
+	if cf, err = c.cut(v, outFilePath); err == nil {
+		v.cutFilePath = outFilePath
+		if cfg.setMTime {
+			v.setMTime(outFilePath)
+		}
+		log.WithFields(log.Fields{"key": v.key}).Infof("Video has been cut with %s: %s", name, outFilePath)
+	}
+
+	return cf, err
 }
 
 // timeStr takes a time duration or point in time as floating point and
@@ -0,0 +1,234 @@
+// Copyright (C) 2018 Michael Picht
+//
+// This file is part of gool (Online TV Recorder on Linux in Go).
+//
+// gool is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// gool is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with gool.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+// cut_accurate.go implements frame accurate cutting with FFmpeg ("smart
+// rendering"). Cutting with stream copy is only possible at key frames. Thus,
+// for each segment of the cutlist only the parts between the segment
+// boundaries and the nearest key frames inside the segment are re-encoded.
+// Everything between these key frames is copied. Finally, all parts are
+// concatenated.
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Constants related to FFprobe
+const (
+	ffprobeName = "ffprobe"
+)
+
+// accurateCutter cuts videos frame accurately with FFmpeg
+type accurateCutter struct{}
+
+// part of a segment that is either copied or re-encoded
+type cutPart struct {
+	start    float64 // start time (in seconds)
+	end      float64 // end time (in seconds)
+	reencode bool    // true: part needs to be re-encoded
+}
+
+// cut cuts the video v frame accurately. The result is stored in outFilePath.
+// It returns the container format of the cut video
+func (accurateCutter) cut(v *video, outFilePath string) (string, error) {
+	var (
+		err          error
+		tmpDir       string
+		kfs          []float64
+		enc          []string
+		segFilePaths []string
+	)
+
+	// determine key frames of the video
+	if kfs, err = v.keyFrames(); err != nil {
+		v.writeCutErrFile(err.Error() + "\n")
+		return "", err
+	}
+
+	// determine encoder options that fit to the video stream
+	if enc, err = v.encoder(); err != nil {
+		v.writeCutErrFile(err.Error() + "\n")
+		return "", err
+	}
+
+	// create temporary directory for the parts
+	if tmpDir, err = v.createTmpDir(); err != nil {
+		return "", err
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	// total duration of the cut video (needed to calculate the progress)
+	total := v.cl.cutDur()
+
+	// extract the parts of all segments. This accounts for the first 90% of
+	// the progress
+	done := 0.0
+	for i := range v.cl.segs {
+		start, end := v.cl.times(i)
+		for j, pt := range splitAtKeyFrames(start, end, kfs) {
+			segFilePath := fmt.Sprintf("%s/%03d-%d.mkv", tmpDir, i, j)
+			prg := v.ffmpegProgress(pt.end-pt.start, 90*done/total, 90*(done+pt.end-pt.start)/total)
+			if pt.reencode {
+				err = v.ffmpegExtract(pt.start, pt.end, enc, segFilePath, prg)
+			} else {
+				err = v.ffmpegExtract(pt.start, pt.end, nil, segFilePath, prg)
+			}
+			if err != nil {
+				return "", err
+			}
+			segFilePaths = append(segFilePaths, segFilePath)
+			done += pt.end - pt.start
+		}
+	}
+
+	// concatenate all parts
+	return "mkv", v.ffmpegConcat(tmpDir, segFilePaths, outFilePath, v.ffmpegProgress(total, 90, 100))
+}
+
+// splitAtKeyFrames splits the segment [start, end] into parts: The part from
+// start to the first key frame in the segment and the part from the last key
+// frame in the segment to end need to be re-encoded. The part in between can
+// be copied. kfs must be sorted ascending.
+func splitAtKeyFrames(start, end float64, kfs []float64) []cutPart {
+	// first key frame at or after start
+	i := sort.SearchFloat64s(kfs, start)
+	// first key frame after end
+	j := sort.Search(len(kfs), func(n int) bool { return kfs[n] > end })
+
+	// no (or only one) key frame inside the segment: re-encode everything
+	if j-i < 2 {
+		return []cutPart{{start: start, end: end, reencode: true}}
+	}
+
+	var pts []cutPart
+	if kfs[i] > start {
+		pts = append(pts, cutPart{start: start, end: kfs[i], reencode: true})
+	}
+	pts = append(pts, cutPart{start: kfs[i], end: kfs[j-1], reencode: false})
+	if end > kfs[j-1] {
+		pts = append(pts, cutPart{start: kfs[j-1], end: end, reencode: true})
+	}
+
+	return pts
+}
+
+// keyFrames determines the timestamps (in seconds) of the key frames of the
+// video by calling ffprobe. The timestamps are returned sorted ascending
+func (v *video) keyFrames() ([]float64, error) {
+	var kfs []float64
+
+	cmd := exec.Command(ffprobeName,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "packet=pts_time,dts_time,flags",
+		"-of", "csv=p=0",
+		v.filePath)
+	log.WithFields(log.Fields{"key": v.key}).Debugf("Probe command: %s", strings.Join(cmd.Args, " "))
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Key frames of %s cannot be determined: %v", v.filePath, err)
+	}
+
+	// each line has the format "pts_time,dts_time,flags". Key frames have
+	// the flag "K"
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ",")
+		if len(fields) < 3 || !strings.Contains(fields[len(fields)-1], "K") {
+			continue
+		}
+		for _, f := range fields[:2] {
+			if t, e := strconv.ParseFloat(f, 64); e == nil {
+				kfs = append(kfs, t)
+				break
+			}
+		}
+	}
+	if len(kfs) == 0 {
+		return nil, fmt.Errorf("%s does not contain key frames", v.filePath)
+	}
+	sort.Float64s(kfs)
+
+	return kfs, nil
+}
+
+// encoder determines the FFmpeg options to re-encode parts of the video: The
+// encoder fits to the codec of the video. Profile, level, pixel format and
+// time base are taken over from the video, since the re-encoded parts are
+// concatenated with copied parts of the original stream
+func (v *video) encoder() ([]string, error) {
+	if v.mi == nil {
+		if err := v.probe(); err != nil {
+			return nil, err
+		}
+	}
+	mi := v.mi
+
+	var args []string
+	switch mi.vCodec {
+	case "h264":
+		args = []string{"-c:v", "libx264"}
+		if p := x264Profile(mi.profile); p != "" {
+			args = append(args, "-profile:v", p)
+		}
+		if mi.level > 0 {
+			args = append(args, "-level:v", fmt.Sprintf("%d.%d", mi.level/10, mi.level%10))
+		}
+	case "hevc":
+		args = []string{"-c:v", "libx265"}
+		if mi.profile != "" {
+			args = append(args, "-profile:v", strings.ToLower(strings.Replace(mi.profile, " ", "", -1)))
+		}
+		// FFprobe reports the level of HEVC streams multiplied by 30
+		if mi.level > 0 {
+			args = append(args, "-x265-params", fmt.Sprintf("level-idc=%.1f", float64(mi.level)/30))
+		}
+	case "mpeg4":
+		args = []string{"-c:v", "mpeg4"}
+	default:
+		return nil, fmt.Errorf("Video codec '%s' of %s is not supported for accurate cutting", mi.vCodec, v.filePath)
+	}
+	if mi.pixFmt != "" {
+		args = append(args, "-pix_fmt", mi.pixFmt)
+	}
+	if mi.tb != "" {
+		args = append(args, "-enc_time_base:v", mi.tb)
+	}
+
+	return append(args, ffmpegEncoderOpts(args[1])...), nil
+}
+
+// x264Profile converts the name of an H.264 profile as reported by FFprobe
+// (e.g. "Constrained Baseline", "High 10") into the name that libx264 expects
+// (e.g. "baseline", "high10"). If the profile is not supported by libx264, an
+// empty string is returned
+func x264Profile(profile string) string {
+	switch p := strings.ToLower(strings.Replace(profile, " ", "", -1)); p {
+	case "baseline", "constrainedbaseline":
+		return "baseline"
+	case "main", "high", "high10", "high422", "high444", "high444predictive":
+		return strings.TrimSuffix(p, "predictive")
+	}
+	return ""
+}
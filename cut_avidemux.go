@@ -0,0 +1,100 @@
+// Copyright (C) 2018 Michael Picht
+//
+// This file is part of gool (Online TV Recorder on Linux in Go).
+//
+// gool is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// gool is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with gool.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+// cut_avidemux.go implements the cutter for Avidemux. A project script is
+// generated from the cutlist and executed with the command line version of
+// Avidemux.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Constants related to Avidemux
+const (
+	avidemuxName = "avidemux3_cli"
+)
+
+// avidemuxCutter cuts videos with Avidemux
+type avidemuxCutter struct{}
+
+// cut calls avidemux to cut the video v. The result is stored in outFilePath.
+// It returns the container format of the cut video
+func (avidemuxCutter) cut(v *video, outFilePath string) (string, error) {
+	var (
+		err error
+		f   *os.File
+	)
+
+	// write project script into temporary file
+	if f, err = ioutil.TempFile(cfg.wrkDirPath, "tmp-"+v.key+"-"); err != nil {
+		log.WithFields(log.Fields{"key": v.key}).Errorf("Cannot create temporary file: %v", err)
+		return "", err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	_, err = f.WriteString(v.avidemuxScript(outFilePath))
+	_ = f.Close()
+	if err != nil {
+		log.WithFields(log.Fields{"key": v.key}).Errorf("Cannot write \"%s\": %v", f.Name(), err)
+		return "", err
+	}
+
+	// Avidemux doesn't report its progress in a parsable way: Start automatic
+	// progress bar which increments every 0.5s and stop it once Avidemux is done
+	stop := make(chan struct{})
+	go v.autoIncr(prgActCut, 500, stop)
+	defer func() { stop <- struct{}{} }()
+
+	// call Avidemux
+	return "mkv", v.execCut(nil, avidemuxName, "--nogui", "--run", f.Name(), "--quit")
+}
+
+// avidemuxScript creates an Avidemux project script (tinyPy) that loads the
+// video, sets the segments of its cutlist and - if outFilePath is not
+// empty - saves the result as Matroska file with stream copy
+func (v *video) avidemuxScript(outFilePath string) string {
+	s := "#PY  <- Needed to identify #\n"
+	s += "# Generated by gool for " + v.key + "\n"
+	s += "adm = Avidemux()\n"
+	s += "adm.loadVideo(" + strconv.Quote(v.filePath) + ")\n"
+	s += "adm.clearSegments()\n"
+	for i := range v.cl.segs {
+		start, end := v.cl.times(i)
+		s += fmt.Sprintf("adm.addSegment(0, %d, %d)\n", int64(start*1e6), int64((end-start)*1e6))
+	}
+	s += "adm.videoCodec(\"Copy\")\n"
+	s += "adm.audioClearTracks()\n"
+	s += "adm.audioAddTrack(0)\n"
+	s += "adm.audioCodec(0, \"copy\")\n"
+	// if there's a separate AC3 audio file, it's added as external track
+	if v.ac3 != nil && v.ac3.status == vidStatusDec {
+		s += "adm.audioAddExternal(" + strconv.Quote(v.ac3.filePath) + ")\n"
+		s += "adm.audioCodec(1, \"copy\")\n"
+		s += "adm.audioSetLanguage(1, " + strconv.Quote(ac3Language) + ")\n"
+	}
+	s += "adm.setContainer(\"MKV\")\n"
+	if outFilePath != "" {
+		s += "adm.save(" + strconv.Quote(outFilePath) + ")\n"
+	}
+	return s
+}
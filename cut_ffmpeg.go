@@ -0,0 +1,209 @@
+// Copyright (C) 2018 Michael Picht
+//
+// This file is part of gool (Online TV Recorder on Linux in Go).
+//
+// gool is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// gool is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with gool.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+// cut_ffmpeg.go implements the cutter for FFmpeg. Each segment of the cutlist
+// is extracted with stream copy into a temporary file. Afterwards, these
+// files are concatenated with the concat demuxer of FFmpeg.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Constants related to FFmpeg
+const (
+	ffmpegName = "ffmpeg"
+)
+
+// ffmpegCutter cuts videos with FFmpeg (stream copy)
+type ffmpegCutter struct{}
+
+// cut calls ffmpeg to cut the video v. The result is stored in outFilePath.
+// It returns the container format of the cut video
+func (ffmpegCutter) cut(v *video, outFilePath string) (string, error) {
+	var (
+		err          error
+		tmpDir       string
+		segFilePaths []string
+	)
+
+	// create temporary directory for the segments
+	if tmpDir, err = v.createTmpDir(); err != nil {
+		return "", err
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	// total duration of the cut video (needed to calculate the progress)
+	total := v.cl.cutDur()
+
+	// extract segments. This accounts for the first 90% of the progress
+	done := 0.0
+	for i := range v.cl.segs {
+		start, end := v.cl.times(i)
+		segFilePath := fmt.Sprintf("%s/%03d.mkv", tmpDir, i)
+		prg := v.ffmpegProgress(end-start, 90*done/total, 90*(done+end-start)/total)
+		if err = v.ffmpegExtract(start, end, nil, segFilePath, prg); err != nil {
+			return "", err
+		}
+		segFilePaths = append(segFilePaths, segFilePath)
+		done += end - start
+	}
+
+	// concatenate segments
+	return "mkv", v.ffmpegConcat(tmpDir, segFilePaths, outFilePath, v.ffmpegProgress(total, 90, 100))
+}
+
+// createTmpDir creates a temporary directory in the working dir that is used
+// to store intermediate files while the video is cut
+func (v *video) createTmpDir() (string, error) {
+	tmpDir, err := ioutil.TempDir(cfg.wrkDirPath, "tmp-"+v.key+"-")
+	if err != nil {
+		log.WithFields(log.Fields{"key": v.key}).Errorf("Cannot create temporary directory: %v", err)
+	}
+	return tmpDir, err
+}
+
+// ffmpegExtract extracts the part between start and end (in seconds) from
+// the video (and its AC3 audio file, if there's one) and stores it in
+// segFilePath. If enc is empty, the streams are copied. Otherwise, the video
+// stream is re-encoded with the encoder options enc (see encoder()). The
+// progress is reported to the function prg
+func (v *video) ffmpegExtract(start, end float64, enc []string, segFilePath string, prg func(string)) error {
+	hasAC3 := v.ac3 != nil && v.ac3.status == vidStatusDec
+
+	args := []string{"-y", "-v", "error",
+		"-progress", "pipe:1", "-nostats",
+		"-ss", strconv.FormatFloat(start, 'f', 6, 64),
+		"-i", v.filePath,
+	}
+	// if there's a separate AC3 audio file, it's cut in the same way
+	if hasAC3 {
+		args = append(args,
+			"-ss", strconv.FormatFloat(start, 'f', 6, 64),
+			"-i", v.ac3.filePath,
+		)
+	}
+	args = append(args,
+		"-t", strconv.FormatFloat(end-start, 'f', 6, 64),
+		"-map", "0",
+	)
+	if hasAC3 {
+		args = append(args, "-map", "1:a")
+	}
+	if len(enc) == 0 {
+		args = append(args,
+			"-c", "copy",
+			"-avoid_negative_ts", "make_zero",
+		)
+	} else {
+		args = append(args, "-c", "copy")
+		args = append(args, enc...)
+	}
+	args = append(args, segFilePath)
+
+	return v.execCut(prg, ffmpegName, args...)
+}
+
+// ffmpegConcat concatenates the files segFilePaths with the concat demuxer
+// of FFmpeg and stores the result in outFilePath. The list of files that is
+// required by the concat demuxer is stored in tmpDir. The progress is
+// reported to the function prg
+func (v *video) ffmpegConcat(tmpDir string, segFilePaths []string, outFilePath string, prg func(string)) error {
+	var (
+		err     error
+		lstFile string
+	)
+
+	// write list of segment files for concat demuxer
+	for _, segFilePath := range segFilePaths {
+		lstFile += "file '" + strings.Replace(segFilePath, "'", `'\''`, -1) + "'\n"
+	}
+	lstFilePath := tmpDir + "/segments.txt"
+	if err = ioutil.WriteFile(lstFilePath, []byte(lstFile), 0644); err != nil {
+		log.WithFields(log.Fields{"key": v.key}).Errorf("Cannot write \"%s\": %v", lstFilePath, err)
+		return err
+	}
+
+	// concatenate segments
+	args := []string{"-y", "-v", "error",
+		"-progress", "pipe:1", "-nostats",
+		"-f", "concat",
+		"-safe", "0",
+		"-i", lstFilePath,
+		"-map", "0",
+		"-c", "copy",
+	}
+	if v.ac3 != nil && v.ac3.status == vidStatusDec {
+		args = append(args,
+			"-metadata:s:a:1", "language="+ac3Language,
+			"-metadata:s:a:1", "title="+ac3TrackName,
+		)
+	}
+	args = append(args, outFilePath)
+
+	return v.execCut(prg, ffmpegName, args...)
+}
+
+// ffmpegProgress returns a function that parses the progress information that
+// FFmpeg writes to stdout (option "-progress pipe:1") and updates the cut
+// progress bar. The progress of the FFmpeg call, which processes dur seconds
+// of the video, is mapped to the range [from, to] (in percent) of the bar
+func (v *video) ffmpegProgress(dur float64, from, to float64) func(string) {
+	return func(line string) {
+		// FFmpeg reports the processed time in microseconds as "out_time_us"
+		// (newer versions) or "out_time_ms" (older versions, despite its name
+		// in microseconds as well)
+		var val string
+		switch {
+		case strings.HasPrefix(line, "out_time_us="):
+			val = strings.TrimPrefix(line, "out_time_us=")
+		case strings.HasPrefix(line, "out_time_ms="):
+			val = strings.TrimPrefix(line, "out_time_ms=")
+		default:
+			return
+		}
+		us, err := strconv.ParseInt(val, 10, 64)
+		if err != nil || dur <= 0 {
+			return
+		}
+		t := float64(us) / 1e6
+		if t > dur {
+			t = dur
+		}
+		v.setPrgBar(prgActCut, int(from+(to-from)*t/dur))
+	}
+}
+
+// ffmpegEncoderOpts returns the FFmpeg options that are used for re-encoding
+// with the encoder vCodec. The quality is chosen high, since only short parts
+// of the video are re-encoded
+func ffmpegEncoderOpts(vCodec string) []string {
+	switch vCodec {
+	case "libx264", "libx265":
+		return []string{"-preset", "medium", "-crf", "16"}
+	case "mpeg4":
+		return []string{"-q:v", "2", "-vtag", "XVID"}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+// Copyright (C) 2018 Michael Picht
+//
+// This file is part of gool (Online TV Recorder on Linux in Go).
+//
+// gool is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// gool is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with gool.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+// cut_mkvmerge.go implements the cutter for MKVmerge. It cuts the video by
+// splitting it into parts and appending the parts that shall be kept.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Constants related to MKVmerge
+const (
+	mkvmergeName = "mkvmerge"
+)
+
+// reMKVmergePrg matches the progress lines that MKVmerge writes to stdout in
+// GUI mode (e.g. "#GUI#progress 45%")
+var reMKVmergePrg = regexp.MustCompile(`^#GUI#progress\s+(\d+)%`)
+
+// mkvmergeCutter cuts videos with MKVmerge
+type mkvmergeCutter struct{}
+
+// cut calls mkvmerge to cut the video v. The result is stored in outFilePath.
+// It returns the container format of the cut video
+func (mkvmergeCutter) cut(v *video, outFilePath string) (string, error) {
+	var splitStr string
+
+	// create temporary directory for the metadata files
+	tmpDir, err := v.createTmpDir()
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	// write global tags
+	tagsPath, err := v.writeMKVTags(tmpDir)
+	if err != nil {
+		v.writeCutErrFile(err.Error() + "\n")
+		return "", err
+	}
+
+	// create split string for MKVmerge
+	if v.cl.frameBased {
+		splitStr = "parts-frames:"
+		for i := 0; i < len(v.cl.segs); i++ {
+			if i > 0 {
+				splitStr += ",+"
+			}
+			splitStr += strconv.Itoa(v.cl.segs[i].frameStart) + "-" + strconv.Itoa(v.cl.segs[i].frameStart+v.cl.segs[i].frameDur)
+		}
+	} else {
+		splitStr = "parts:"
+		for i := 0; i < len(v.cl.segs); i++ {
+			if i > 0 {
+				splitStr += ",+"
+			}
+			splitStr += timeStr(v.cl.segs[i].timeStart) + "-" + timeStr(v.cl.segs[i].timeStart+v.cl.segs[i].timeDur)
+		}
+	}
+
+	// assemble arguments for MKVmerge: Output file, split string and the video
+	args := []string{
+		"--gui-mode",
+		"-o", outFilePath,
+		"--title", v.mkvTitle(),
+		"--global-tags", tagsPath,
+		"--split", splitStr,
+	}
+	// if there's a separate AC3 audio file, it's added as additional audio
+	// track and becomes the default audio track. The audio tracks of the
+	// video are no default tracks anymore. mkvmerge applies the split to all
+	// tracks, thus the audio is cut with the same cutlist as the video
+	hasAC3 := v.ac3 != nil && v.ac3.status == vidStatusDec
+	if hasAC3 {
+		ids, err := audioTrackIDs(v.filePath)
+		if err != nil {
+			v.writeCutErrFile(err.Error() + "\n")
+			return "", err
+		}
+		for _, id := range ids {
+			args = append(args, "--default-track", strconv.Itoa(id)+":no")
+		}
+	}
+	args = append(args, v.filePath)
+	if hasAC3 {
+		args = append(args,
+			"--language", "0:"+ac3Language,
+			"--track-name", "0:"+ac3TrackName,
+			"--default-track", "0:yes",
+			v.ac3.filePath,
+		)
+	}
+
+	// call MKVmerge
+	if err = v.execCut(v.mkvmergeProgress, mkvmergeName, args...); err != nil {
+		return "mkv", err
+	}
+
+	// add chapters (if configured). Since the video has been cut
+	// successfully, errors are only logged
+	if cfg.mkvChapters {
+		if err = v.addMKVChapters(tmpDir, outFilePath); err != nil {
+			log.WithFields(log.Fields{"key": v.key}).Warn(err.Error())
+		}
+	}
+
+	return "mkv", nil
+}
+
+// audioTrackIDs determines the IDs of the audio tracks of the file filePath
+// by calling "mkvmerge -J"
+func audioTrackIDs(filePath string) ([]int, error) {
+	out, err := exec.Command(mkvmergeName, "-J", filePath).Output()
+	if err != nil {
+		return nil, fmt.Errorf("Tracks of %s cannot be determined: %v", filePath, err)
+	}
+	var id mkvmergeIdent
+	if err = json.Unmarshal(out, &id); err != nil {
+		return nil, fmt.Errorf("Tracks of %s cannot be interpreted: %v", filePath, err)
+	}
+	var ids []int
+	for _, tr := range id.Tracks {
+		if tr.Type == "audio" {
+			ids = append(ids, tr.ID)
+		}
+	}
+	return ids, nil
+}
+
+// mkvmergeProgress parses one line of the MKVmerge output and updates the
+// cut progress bar accordingly
+func (v *video) mkvmergeProgress(line string) {
+	if m := reMKVmergePrg.FindStringSubmatch(line); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		v.setPrgBar(prgActCut, n)
+	}
+}
@@ -25,14 +25,11 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"net/http"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 
-	"github.com/go-ini/ini"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/html/charset"
 )
@@ -47,30 +44,88 @@ type seg struct {
 }
 type cutlist struct {
 	id         string
+	src        string // file path for local cutlists (empty for cutlists from the server)
 	app        string
 	ratio      string
 	fps        float64
+	applyTo    string // name of the file the cutlist has been created for
+	origSize   int64  // size (in bytes) of the file the cutlist has been created for
 	timeBased  bool
 	frameBased bool
 	segs       []*seg // the list of cuts
+	movieName  string // suggested name of the movie (section [Info])
+	content    string // actual content of the video (section [Info])
+	comment    string // comment of the author (section [Info])
+	author     string // author of the cutlist (section [Info] or cutlist header)
+	reason     string // reason why the cutlist has been picked
+	match      string // how the cutlist has been found (see clHeader)
+}
+
+// times returns start and end (in seconds) of the i-th segment of the cutlist.
+// If the cutlist doesn't contain time information, it's calculated from the
+// frame information and the frame rate
+func (cl *cutlist) times(i int) (float64, float64) {
+	sg := cl.segs[i]
+	if cl.timeBased || cl.fps == 0 {
+		return sg.timeStart, sg.timeStart + sg.timeDur
+	}
+	return float64(sg.frameStart) / cl.fps, float64(sg.frameStart+sg.frameDur) / cl.fps
+}
+
+// cutDur returns the duration (in seconds) of the cut video, i.e. the sum of
+// the durations of all segments of the cutlist
+func (cl *cutlist) cutDur() float64 {
+	var dur float64
+	for i := range cl.segs {
+		start, end := cl.times(i)
+		dur += end - start
+	}
+	return dur
 }
 
 // An array of clHeader is used to store the header information of the cutlists
 // retrieved from the cutlist server. The score will be calculated based on the
-// ratings. It will also be used to sort the array.
+// ratings and the cutlist policy. It will also be used to sort the array.
 type clHeader struct {
-	score float64
-	id    string
+	score          float64
+	id             string
+	name           string   // name of the file the cutlist has been created for
+	author         string   // author of the cutlist
+	rating         float64  // average rating of the cutlist users
+	ratingCount    int      // number of ratings
+	ratingByAuthor float64  // rating of the author
+	downloadCount  int      // number of downloads
+	comment        string   // user comment
+	errors         []string // error flags (e.g. "EPGError", "MissingEnding")
+	match          string   // how the cutlist has been found (e.g. by file size)
+	variant        string   // variant of the key for cutlists found by fuzzy lookup
+	rejected       bool     // cutlist has been rejected by the cutlist policy
+	reason         string   // reason why the cutlist was picked or rejected
 }
-type clHeaders []clHeader
-
-// implement sort interface for cutlist headers
-func (clhs clHeaders) Len() int           { return len(clhs) }
-func (clhs clHeaders) Less(i, j int) bool { return clhs[i].score > clhs[j].score } // sort descending by score
-func (clhs clHeaders) Swap(i, j int)      { clhs[i], clhs[j] = clhs[j], clhs[i] }
+type clHeaders []*clHeader
+
+// implement sort interface for cutlist headers: sort descending by score, then by
+// number of ratings and by number of downloads
+func (clhs clHeaders) Len() int { return len(clhs) }
+func (clhs clHeaders) Less(i, j int) bool {
+	if clhs[i].score != clhs[j].score {
+		return clhs[i].score > clhs[j].score
+	}
+	if clhs[i].ratingCount != clhs[j].ratingCount {
+		return clhs[i].ratingCount > clhs[j].ratingCount
+	}
+	return clhs[i].downloadCount > clhs[j].downloadCount
+}
+func (clhs clHeaders) Swap(i, j int) { clhs[i], clhs[j] = clhs[j], clhs[i] }
 
-// hasCutlists checks if the cutlist server has cutlists for that video
+// hasCutlists checks if there are local cutlist files or if the cutlist server has
+// cutlists for that video
 func (v *video) hasCutlists() bool {
+	// local cutlist files exist
+	if len(v.clFiles) > 0 {
+		return true
+	}
+
 	// load cutlist headers from cutlist.at. If no lists could be retrieved: Log message and return
 	if len(v.loadCutlistHeaders()) == 0 {
 		log.WithFields(log.Fields{"key": v.key}).Warn("No cutlist header could be loaded.")
@@ -86,8 +141,6 @@ func (v *video) loadCutlist(wg *sync.WaitGroup, r chan<- res) {
 	// Decrease wait group counter when function is finished
 	defer wg.Done()
 
-	var ids []string
-
 	// create stop channel for progress bar
 	stop := make(chan struct{})
 
@@ -97,241 +150,278 @@ func (v *video) loadCutlist(wg *sync.WaitGroup, r chan<- res) {
 	// stop progress bar once fetchCutlists finalizes
 	defer func() { stop <- struct{}{} }()
 
+	// select cutlist and write the result into the results channel
+	var err error
+	v.cl, err = v.selectCutlist()
+	r <- res{key: v.key, err: err}
+}
+
+// selectCutlist determines the cutlist for the video: A cutlist that has been
+// chosen by the user has highest priority, followed by a cutlist that has
+// been accepted in a preview, local cutlist files and cutlists from the
+// cutlist server
+func (v *video) selectCutlist() (*cutlist, error) {
+	var (
+		cl   *cutlist
+		clhs clHeaders
+		err  error
+	)
+
+	// a cutlist that has been chosen by the user has highest priority
+	if v.clID != "" {
+		if cl, err = v.loadChosenCutlist(); err != nil {
+			log.WithFields(log.Fields{"key": v.key}).Error(err.Error())
+		}
+		return cl, err
+	}
+
+	// followed by a cutlist that has been accepted in a preview
+	if cl = v.loadAcceptedCutlist(); cl != nil {
+		log.WithFields(log.Fields{"key": v.key}).Info(cl.reason)
+		return cl, nil
+	}
+
+	// local cutlist files are preferred over cutlists from the cutlist server
+	if cl = v.loadLocalCutlist(); cl != nil {
+		log.WithFields(log.Fields{"key": v.key}).Infof("Use local cutlist %s", cl.src)
+		return cl, nil
+	}
+
 	// load cutlist headers from cutlist.at. If no lists could be retrieved: Print error
 	// message and return
-	if ids = v.loadCutlistHeaders(); len(ids) == 0 {
+	if clhs = v.loadCutlistHeaders(); len(clhs) == 0 {
 		log.WithFields(log.Fields{"key": v.key}).Warn("No cutlist header could be loaded")
-		r <- res{key: v.key, err: fmt.Errorf("No cutlist found")}
-		return
+		return nil, fmt.Errorf("No cutlist found")
 	}
 
 	// retrieve cutlist from cutlist.at using the cutlist header list. If no cutlist could
 	// be retrieved: Print error message and return
-	if v.cl = v.loadCutlistDetails(ids); v.cl == nil {
+	if cl = v.loadCutlistDetails(clhs); cl == nil {
 		log.WithFields(log.Fields{"key": v.key}).Warn("No cutlist header could be loaded")
-		r <- res{key: v.key, err: fmt.Errorf("No cutlists cut be fetched")}
-		return
+		return nil, fmt.Errorf("No cutlists cut be fetched")
 	}
 
-	// Cutlist fetched: Write nil error into results channel
-	r <- res{key: v.key, err: nil}
+	return cl, nil
+}
+
+// currentCutlist returns the cutlist that applies to the video outside of
+// processing (e.g. for the sub commands of "gool cutlist"): A cutlist that
+// has been chosen by the user, the cutlist that has been used to cut the
+// video or the cutlist that would be selected to cut it
+func (v *video) currentCutlist() (*cutlist, error) {
+	if v.clID == "" && v.status == vidStatusCut {
+		if rec := cutRecOf(v.key); rec != nil {
+			if cl := rec.cutlist(); cl != nil {
+				cl.reason = "Cutlist that has been used to cut the video"
+				return cl, nil
+			}
+		}
+	}
+	return v.selectCutlist()
+}
+
+// checkCutlist checks if the cutlist has been created for the video: If the
+// cutlist contains the name of the file it has been created for (ApplyToFile),
+// it must be a file of the video. If the cutlist contains the size of that
+// file and the size of the decoded video is known, both sizes must be equal.
+// Cutlists that have been found by fuzzy lookup are not checked
+func (v *video) checkCutlist(cl *cutlist) error {
+	// cutlists that have been found by fuzzy lookup have been created for
+	// another version of the broadcast by definition
+	if cl.match == clMatchFuzzy {
+		return nil
+	}
+	if cl.applyTo != "" && !v.isFileOfVideo(cl.applyTo) {
+		return fmt.Errorf("Cutlist has been created for %s", cl.applyTo)
+	}
+	if cl.origSize > 0 && v.size > 0 && cl.origSize != v.size {
+		return fmt.Errorf("Cutlist has been created for a file of %d bytes, but the decoded video has %d bytes", cl.origSize, v.size)
+	}
+	return nil
 }
 
 // loadCutlist loops at a (sorted) cutlist header list and fetches the corresponding
 // cutlist. In case of success, it returns. In case of failure, it continues with
 // the next entry of the list
-func (v *video) loadCutlistDetails(ids []string) *cutlist {
-
-	// constants for cl INI file sections and keys
-	const (
-		clSectionGeneral = "general"
-		clKeyNumCuts     = "noofcuts"
-		clKeyRatio       = "displayaspectratio"
-		clKeyApp         = "intendedcutapplicationname"
-		clKeyFPS         = "framespersecond"
-		clSectionCut     = "cut"
-		clKeyTimeStart   = "start"
-		clKeyTimeDur     = "duration"
-		clKeyFrameStart  = "startframe"
-		clKeyFrameDur    = "durationframes"
-	)
-
-	var (
-		err         error
-		cl          *cutlist
-		clRetrieved bool
-	)
-
+func (v *video) loadCutlistDetails(clhs clHeaders) *cutlist {
 	// Loop over the cutlist headers and fetch the correspond cutlist.
 	// In case of success: return the cutlist
-	for _, id := range ids {
+	for _, clh := range clhs {
+		id := clh.id
 		var (
-			resp    *http.Response
-			clINI   []byte
-			clFile  *ini.File
-			sec     *ini.Section
-			key     *ini.Key
-			numCuts int
-			sg      *seg
+			clINI []byte
+			cl    *cutlist
+			err   error
 		)
 
-		clRetrieved = false
-
-		// create new cutlist
-		cl = new(cutlist)
-		cl.id = id
-
-		// load cutlist from cutlist.at by calling URL
-		if resp, err = http.Get(cfg.clsURL + "getfile.php?id=" + id); err != nil {
+		// load cutlist from cache or cutlist server
+		if clINI, err = fetchCutlistFile(id); err != nil {
 			// if no cutlist could be fetched: Nothing left to do, try next
-			continue
-		}
-		// read data
-		clINI, err = ioutil.ReadAll(resp.Body)
-		_ = resp.Body.Close()
-		// if data couldn't be read: Nothing left to do, try next
-		if err != nil {
+			log.WithFields(log.Fields{"key": v.key}).Warnf("Cutlist ID=%s could not be fetched: %v", id, err)
 			continue
 		}
 
-		// open cutlist INI data source with go-ini
-		if clFile, err = ini.InsensitiveLoad(clINI); err != nil {
-			log.WithFields(log.Fields{"key": v.key}).Errorf("Cutlist file could not be opened for ID '%s': %v", id, err)
+		// parse cutlist. If that's not possible: try next
+		if cl, err = parseCutlist(id, clINI); err != nil {
+			log.WithFields(log.Fields{"key": v.key}).Error(err.Error())
 			continue
 		}
 
-		// get GENERAL section
-		if sec, err = clFile.GetSection(clSectionGeneral); err != nil {
-			log.WithFields(log.Fields{"key": v.key}).Errorf("Cutlist ID=%s does not have section '%s': %v", id, clSectionGeneral, err)
+		// check if the cutlist has been created for this video. If not: try next
+		cl.match = clh.match
+		if cl.author == "" {
+			cl.author = clh.author
+		}
+		if err = v.checkCutlist(cl); err != nil {
+			log.WithFields(log.Fields{"key": v.key}).Warnf("Cutlist ID=%s is not used: %v", id, err)
+			clh.rejected = true
+			clh.reason = err.Error()
 			continue
 		}
 
-		// get display aspect ration
-		if key, err = sec.GetKey(clKeyRatio); err != nil {
-			log.WithFields(log.Fields{"key": v.key}).Warnf("Cutlist ID=%s does not have key '%s'", id, clKeyRatio)
-		} else {
-			cl.ratio = key.Value()
-		}
+		// cutlist has been parsed successfully: return it
+		cl.reason = clh.string()
+		log.WithFields(log.Fields{"key": v.key}).Info(cl.reason)
+		return cl
+	}
 
-		// get frames per second
-		if key, err = sec.GetKey(clKeyFPS); err != nil {
-			log.WithFields(log.Fields{"key": v.key}).Warnf("Cutlist ID=%s does not have key '%s'", id, clKeyFPS)
-		} else {
-			cl.fps, _ = strconv.ParseFloat(key.Value(), 64)
-		}
+	return nil
+}
 
-		// get intended cut application
-		if key, err = sec.GetKey(clKeyApp); err != nil {
-			log.WithFields(log.Fields{"key": v.key}).Warnf("Cutlist ID=%s does not have key '%s'", id, clKeyApp)
-		} else {
-			cl.app = key.Value()
-		}
+// loadCutlistHeaders requests cutlist header information for the cutlist server
+// for the video and applies the cutlist policy. All headers (incl. the rejected
+// ones) are stored in the video. The accepted headers are returned, sorted
+// descending by score
+func (v *video) loadCutlistHeaders() clHeaders {
+	var clhs clHeaders
+
+	// if the size of the decoded file is known, cutlists are looked up by file
+	// size first, since these cutlists have been created for exactly that file
+	if v.size > 0 {
+		clhs = v.fetchCutlistHeaders("ofsb="+strconv.FormatInt(v.size, 10), clMatchSize)
+	}
 
-		// get number of cuts
-		if key, err = sec.GetKey(clKeyNumCuts); err != nil {
-			log.WithFields(log.Fields{"key": v.key}).Errorf("Cutlist ID=%s does not have key '%s'", id, clKeyNumCuts)
-			continue
+	// look up cutlists by the key of the video
+	clhs = clhs.merge(v.fetchCutlistHeaders("name="+v.key, clMatchName))
+	for _, clh := range clhs {
+		log.WithFields(log.Fields{"key": v.key}).Infof("Found cutlist ID=%s (match: %s)", clh.id, clh.match)
+	}
+
+	// apply cutlist policy (scores and rejects cutlists)
+	cfg.clPolicy.apply(clhs)
+	v.rejectPreviewed(clhs)
+
+	// if no cutlist has been accepted: look up cutlists for variants of the
+	// key. Cutlists that are found by the fuzzy lookup replace the ones with
+	// the same ID that have been found before (since these have been rejected
+	// as they have been created for another file)
+	if len(clhs.accepted()) == 0 {
+		if fuzzy := v.fuzzyCutlistHeaders(); len(fuzzy) > 0 {
+			clhs = fuzzy.merge(clhs)
+			cfg.clPolicy.apply(clhs)
+			v.rejectPreviewed(clhs)
 		}
-		numCuts, _ = strconv.Atoi(key.Value())
-
-		// read cuts
-		for i := 0; i < numCuts; i++ {
-			// get [Cut{i}] section
-			if sec, err = clFile.GetSection(clSectionCut + strconv.Itoa(i)); err != nil {
-				log.WithFields(log.Fields{"key": v.key}).Errorf("Cutlist ID=%s does not have section '%s'.", id, clSectionCut+strconv.Itoa(i))
-				break
-			}
-			sg = new(seg)
-			// get start time
-			if sec.HasKey(clKeyTimeStart) {
-				key, _ = sec.GetKey(clKeyTimeStart)
-				if i == 0 {
-					cl.timeBased = true
-				}
-				sg.timeStart, _ = strconv.ParseFloat(key.Value(), 64)
-			}
-			// get time duration
-			if sec.HasKey(clKeyTimeDur) {
-				key, _ = sec.GetKey(clKeyTimeDur)
-				sg.timeDur, _ = strconv.ParseFloat(key.Value(), 64)
-			}
-			// get start frame
-			if sec.HasKey(clKeyFrameStart) {
-				key, _ = sec.GetKey(clKeyFrameStart)
-				if i == 0 {
-					cl.frameBased = true
-				}
-				sg.frameStart, _ = strconv.Atoi(key.Value())
-			}
-			// get frames duration
-			if sec.HasKey(clKeyFrameDur) {
-				key, _ = sec.GetKey(clKeyFrameDur)
-				sg.frameDur, _ = strconv.Atoi(key.Value())
-			}
+	}
+	v.clhs = clhs
 
-			// consistense checks:
-			// - verify that all cuts have frame information (if the first one had)
-			if cl.frameBased && (sg.frameStart == 0 && sg.frameDur == 0) {
-				log.WithFields(log.Fields{"key": v.key}).Errorf("Cutlist ID=%s: Cut %s is missing frame information", id, clSectionCut+strconv.Itoa(i))
-				cl.segs = cl.segs[:0]
-				break
-			}
-			// consistense checks:
-			// - verify that all cuts have time information (if the first one had)
-			if cl.timeBased && (sg.timeStart == 0 && sg.timeDur == 0) {
-				log.WithFields(log.Fields{"key": v.key}).Errorf("Cutlist ID=%s: Cut %s is missing time information", id, clSectionCut+strconv.Itoa(i))
-				cl.segs = cl.segs[:0]
-				break
-			}
-			// - verify the all cuts have either frame or time information or both
-			if (sg.timeStart == 0.0 && sg.timeDur == 0.0) && (sg.frameStart == 0 && sg.frameDur == 0) {
-				log.WithFields(log.Fields{"key": v.key}).Errorf("Cutlist ID=%s: Cut %s does not have sufficient information", id, clSectionCut+strconv.Itoa(i))
-				cl.segs = cl.segs[:0]
-				break
-			}
+	// sort clHeaders descending by score
+	sort.Sort(clhs)
 
-			cl.segs = append(cl.segs, sg)
-		}
-		// if no cuts
-		if len(cl.segs) == 0 {
-			continue
+	return clhs.accepted()
+}
+
+// fetchCutlistHeaders requests cutlist header information for the query (e.g.
+// "name=<key>") from the cache or the cutlist server and parses it. The
+// headers are labelled with match (i.e. how they have been found). For
+// headers that have been found by name, it's checked whether they have been
+// created for a file of this video. If not, they are labelled with
+// clMatchNone.
+func (v *video) fetchCutlistHeaders(query string, match string) clHeaders {
+	var (
+		clhs  clHeaders
+		err   error
+		clXML []byte
+	)
+
+	// load cutlist header from cache or cutlist server
+	if clXML, err = fetchCutlistHeaders(query); err != nil {
+		// if no culist could be fetched: Nothing left to do, return
+		log.WithFields(log.Fields{"key": v.key}).Warnf("Cutlist headers could not be fetched: %v", err)
+		return nil
+	}
+	if clhs, err = parseCutlistHeaders(clXML); err != nil {
+		log.WithFields(log.Fields{"key": v.key}).Errorf("Error while reading cutlist headers: %v", err)
+		return nil
+	}
+
+	for _, clh := range clhs {
+		clh.match = match
+		if match == clMatchName && clh.name != "" && !v.isFileOfVideo(clh.name) {
+			clh.match = clMatchNone
 		}
+	}
+
+	return clhs
+}
 
-		// cutlist has been parsed successfully: set clRetrieved accordingly
-		//and leave loop
-		clRetrieved = true
-		break
+// merge appends the headers of other that are not yet contained in clhs
+func (clhs clHeaders) merge(other clHeaders) clHeaders {
+	ids := make(map[string]struct{})
+	for _, clh := range clhs {
+		ids[clh.id] = struct{}{}
+	}
+	for _, clh := range other {
+		if _, exists := ids[clh.id]; !exists {
+			clhs = append(clhs, clh)
+			ids[clh.id] = struct{}{}
+		}
 	}
+	return clhs
+}
 
-	// return either cutlist or nil
-	if clRetrieved {
-		return cl
+// accepted returns the cutlist headers that haven't been rejected
+func (clhs clHeaders) accepted() clHeaders {
+	var acc clHeaders
+	for _, clh := range clhs {
+		if !clh.rejected {
+			acc = append(acc, clh)
+		}
 	}
-	return nil
+	return acc
 }
 
-// loadCutlistHeaders requests cutlist header information for the cutlist server
-// for the video. It returns the information as list of clHeader, sorted descending
-// by score
-func (v *video) loadCutlistHeaders() []string {
+// parseCutlistHeaders parses the cutlist header information (XML) that is
+// returned by the cutlist server
+func parseCutlistHeaders(clXML []byte) (clHeaders, error) {
 	var (
-		ids   []string
-		clhs  clHeaders
-		clh   clHeader
-		resp  *http.Response
-		err   error
-		clXML []byte
-		el    string
+		clhs clHeaders
+		el   string
 	)
 
 	// constants for relevant element names of cutlist headers
 	const (
-		clTagID      = "ID"
-		clTagRating  = "RATING"
-		clTagCutlist = "CUTLIST"
+		clTagID               = "ID"
+		clTagName             = "NAME"
+		clTagRating           = "RATING"
+		clTagRatingCount      = "RATINGCOUNT"
+		clTagRatingByAuthor   = "RATINGBYAUTHOR"
+		clTagAuthor           = "AUTHOR"
+		clTagDownloadCount    = "DOWNLOADCOUNT"
+		clTagComment          = "USERCOMMENT"
+		clTagErrors           = "ERRORS"
+		clTagEPGError         = "EPGERROR"
+		clTagMissingBeginning = "MISSINGBEGINNING"
+		clTagMissingEnding    = "MISSINGENDING"
+		clTagOtherError       = "OTHERERROR"
+		clTagCutlist          = "CUTLIST"
 	)
 
 	// array of relevant element names
-	clRelNames := [...]string{clTagID, clTagRating}
+	clRelNames := [...]string{clTagID, clTagName, clTagRating, clTagRatingCount, clTagRatingByAuthor, clTagAuthor,
+		clTagDownloadCount, clTagComment, clTagErrors, clTagEPGError, clTagMissingBeginning, clTagMissingEnding, clTagOtherError}
 	// map to store values of relevant element values for one cutlist
 	var clRelVals map[string]string
 
-	log.WithFields(log.Fields{"key": v.key}).Debugf("Call cutlist.at: %sgetxml.php?name=%s", cfg.clsURL, v.key)
-
-	// load cutlist header from cutlist.at by calling URL
-	if resp, err = http.Get(cfg.clsURL + "getxml.php?name=" + v.key); err != nil {
-		// if no culist could be fetched: Nothing left to do, return
-		return ids
-	}
-
-	// read data
-	clXML, err = ioutil.ReadAll(resp.Body)
-	_ = resp.Body.Close()
-	// if data couldn't be read: Nothing to do, return
-	if err != nil {
-		log.WithFields(log.Fields{"key": v.key}).Errorf("Cannot read XML body: %v", err)
-		return ids
-	}
 	dec := xml.NewDecoder(bytes.NewReader(clXML))
 	dec.CharsetReader = charset.NewReaderLabel
 	// FROM: https://stackoverflow.com/questions/6002619/unmarshal-an-iso-8859-1-xml-input-in-go#32224438
@@ -340,8 +430,7 @@ func (v *video) loadCutlistHeaders() []string {
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			log.WithFields(log.Fields{"key": v.key}).Errorf("Error while reading cutlist headers: %v", err)
-			return ids
+			return clhs, err
 		}
 
 		switch tok := tok.(type) {
@@ -368,9 +457,21 @@ func (v *video) loadCutlistHeaders() []string {
 			// if the end of a cutlist has been reached ...
 			if strings.ToUpper(tok.Name.Local) == clTagCutlist {
 				// fill custlist header struct ...
+				clh := new(clHeader)
 				clh.id = clRelVals[clTagID]
-				log.WithFields(log.Fields{"key": v.key}).Infof("Found cutlist ID=%s", clh.id)
-				clh.score, _ = strconv.ParseFloat(clRelVals[clTagRating], 64)
+				clh.name = clRelVals[clTagName]
+				clh.author = clRelVals[clTagAuthor]
+				clh.comment = clRelVals[clTagComment]
+				clh.rating, _ = strconv.ParseFloat(clRelVals[clTagRating], 64)
+				clh.ratingCount, _ = strconv.Atoi(clRelVals[clTagRatingCount])
+				clh.ratingByAuthor, _ = strconv.ParseFloat(clRelVals[clTagRatingByAuthor], 64)
+				clh.downloadCount, _ = strconv.Atoi(clRelVals[clTagDownloadCount])
+				clh.errors = clErrors(clRelVals[clTagErrors], map[string]string{
+					clErrEPG:              clRelVals[clTagEPGError],
+					clErrMissingBeginning: clRelVals[clTagMissingBeginning],
+					clErrMissingEnding:    clRelVals[clTagMissingEnding],
+					clErrOther:            clRelVals[clTagOtherError],
+				})
 				// and append it to the header list
 				if clh.id != "" {
 					clhs = append(clhs, clh)
@@ -378,21 +479,12 @@ func (v *video) loadCutlistHeaders() []string {
 			}
 		case xml.CharData:
 			// if element is relecvant ...
-			if el != "" {
+			if el != "" && clRelVals != nil {
 				// store value for later processing
-				clRelVals[el] = string(tok)
+				clRelVals[el] = strings.TrimSpace(string(tok))
 			}
 		}
 	}
 
-	// sort clHeaders descending by score
-	sort.Sort(clhs)
-
-	// build up cutlist array for cutlist header array
-	for _, clh := range clhs {
-		id := clh.id
-		ids = append(ids, id)
-	}
-
-	return ids
+	return clhs, nil
 }
@@ -0,0 +1,443 @@
+// Copyright (C) 2018 Michael Picht
+//
+// This file is part of gool (Online TV Recorder on Linux in Go).
+//
+// gool is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// gool is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with gool. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+// cutlistfile.go implements the parsing of cutlist files (INI format) and
+// the handling of local cutlist files, i.e. files with the suffix ".cutlist"
+// that are stored next to the video, in the working dir or in the configured
+// cutlist dir, or that have been passed via command line. Local cutlists are
+// preferred over cutlists from the cutlist server.
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-ini/ini"
+	log "github.com/sirupsen/logrus"
+)
+
+// constants for cl INI file sections and keys
+const (
+	clSectionGeneral = "general"
+	clKeyNumCuts     = "noofcuts"
+	clKeyRatio       = "displayaspectratio"
+	clKeyApp         = "intendedcutapplicationname"
+	clKeyFPS         = "framespersecond"
+	clKeyApplyTo     = "applytofile"
+	clKeyOrigSize    = "originalfilesizebytes"
+	clSectionCut     = "cut"
+	clKeyTimeStart   = "start"
+	clKeyTimeDur     = "duration"
+	clKeyFrameStart  = "startframe"
+	clKeyFrameDur    = "durationframes"
+	clSectionInfo    = "info"
+	clKeyMovieName   = "suggestedmoviename"
+	clKeyContent     = "actualcontent"
+	clKeyComment     = "usercomment"
+	clKeyAuthor      = "author"
+	clSectionMeta    = "meta"
+	clKeyID          = "cutlistid"
+)
+
+// suffix of local cutlist files
+const clFileSuffix = ".cutlist"
+
+// parseCutlist parses the content of a cutlist file (INI format) and returns
+// the cutlist. id is used as ID of the cutlist and for messages. If the
+// cutlist cannot be parsed or doesn't contain cuts, an error is returned
+func parseCutlist(id string, clINI []byte) (*cutlist, error) {
+	var (
+		err     error
+		clFile  *ini.File
+		sec     *ini.Section
+		key     *ini.Key
+		numCuts int
+		sg      *seg
+	)
+
+	// create new cutlist
+	cl := new(cutlist)
+	cl.id = id
+
+	// open cutlist INI data source with go-ini
+	if clFile, err = ini.InsensitiveLoad(clINI); err != nil {
+		return nil, fmt.Errorf("Cutlist file could not be opened for ID '%s': %v", id, err)
+	}
+
+	// get GENERAL section
+	if sec, err = clFile.GetSection(clSectionGeneral); err != nil {
+		return nil, fmt.Errorf("Cutlist ID=%s does not have section '%s': %v", id, clSectionGeneral, err)
+	}
+
+	// get display aspect ration
+	if key, err = sec.GetKey(clKeyRatio); err != nil {
+		log.Warnf("Cutlist ID=%s does not have key '%s'", id, clKeyRatio)
+	} else {
+		cl.ratio = key.Value()
+	}
+
+	// get frames per second
+	if key, err = sec.GetKey(clKeyFPS); err != nil {
+		log.Warnf("Cutlist ID=%s does not have key '%s'", id, clKeyFPS)
+	} else {
+		cl.fps, _ = strconv.ParseFloat(key.Value(), 64)
+	}
+
+	// get intended cut application
+	if key, err = sec.GetKey(clKeyApp); err != nil {
+		log.Warnf("Cutlist ID=%s does not have key '%s'", id, clKeyApp)
+	} else {
+		cl.app = key.Value()
+	}
+
+	// get name and size of the file the cutlist has been created for
+	cl.applyTo = sec.Key(clKeyApplyTo).Value()
+	cl.origSize, _ = strconv.ParseInt(sec.Key(clKeyOrigSize).Value(), 10, 64)
+
+	// get number of cuts
+	if key, err = sec.GetKey(clKeyNumCuts); err != nil {
+		return nil, fmt.Errorf("Cutlist ID=%s does not have key '%s'", id, clKeyNumCuts)
+	}
+	numCuts, _ = strconv.Atoi(key.Value())
+
+	// read cuts
+	for i := 0; i < numCuts; i++ {
+		// get [Cut{i}] section
+		if sec, err = clFile.GetSection(clSectionCut + strconv.Itoa(i)); err != nil {
+			return nil, fmt.Errorf("Cutlist ID=%s does not have section '%s'", id, clSectionCut+strconv.Itoa(i))
+		}
+		sg = new(seg)
+		// get start time
+		if sec.HasKey(clKeyTimeStart) {
+			key, _ = sec.GetKey(clKeyTimeStart)
+			if i == 0 {
+				cl.timeBased = true
+			}
+			sg.timeStart, _ = strconv.ParseFloat(key.Value(), 64)
+		}
+		// get time duration
+		if sec.HasKey(clKeyTimeDur) {
+			key, _ = sec.GetKey(clKeyTimeDur)
+			sg.timeDur, _ = strconv.ParseFloat(key.Value(), 64)
+		}
+		// get start frame
+		if sec.HasKey(clKeyFrameStart) {
+			key, _ = sec.GetKey(clKeyFrameStart)
+			if i == 0 {
+				cl.frameBased = true
+			}
+			sg.frameStart, _ = strconv.Atoi(key.Value())
+		}
+		// get frames duration
+		if sec.HasKey(clKeyFrameDur) {
+			key, _ = sec.GetKey(clKeyFrameDur)
+			sg.frameDur, _ = strconv.Atoi(key.Value())
+		}
+
+		// consistense checks:
+		// - verify that all cuts have frame information (if the first one had)
+		if cl.frameBased && (sg.frameStart == 0 && sg.frameDur == 0) {
+			return nil, fmt.Errorf("Cutlist ID=%s: Cut %s is missing frame information", id, clSectionCut+strconv.Itoa(i))
+		}
+		// consistense checks:
+		// - verify that all cuts have time information (if the first one had)
+		if cl.timeBased && (sg.timeStart == 0 && sg.timeDur == 0) {
+			return nil, fmt.Errorf("Cutlist ID=%s: Cut %s is missing time information", id, clSectionCut+strconv.Itoa(i))
+		}
+		// - verify the all cuts have either frame or time information or both
+		if (sg.timeStart == 0.0 && sg.timeDur == 0.0) && (sg.frameStart == 0 && sg.frameDur == 0) {
+			return nil, fmt.Errorf("Cutlist ID=%s: Cut %s does not have sufficient information", id, clSectionCut+strconv.Itoa(i))
+		}
+
+		cl.segs = append(cl.segs, sg)
+	}
+	// if no cuts
+	if len(cl.segs) == 0 {
+		return nil, fmt.Errorf("Cutlist ID=%s does not contain cuts", id)
+	}
+
+	// get information about the content (optional)
+	if sec, err = clFile.GetSection(clSectionInfo); err == nil {
+		cl.movieName = strings.TrimSpace(sec.Key(clKeyMovieName).Value())
+		cl.content = strings.TrimSpace(sec.Key(clKeyContent).Value())
+		cl.comment = strings.TrimSpace(sec.Key(clKeyComment).Value())
+		cl.author = strings.TrimSpace(sec.Key(clKeyAuthor).Value())
+	}
+
+	return cl, nil
+}
+
+// readCutlistFile reads and parses the local cutlist file filePath. If the
+// file contains a cutlist ID (section [Meta]), it's used as ID. Otherwise,
+// the file path is used
+func readCutlistFile(filePath string) (*cutlist, error) {
+	var (
+		data []byte
+		cl   *cutlist
+		err  error
+	)
+
+	if data, err = ioutil.ReadFile(filePath); err != nil {
+		return nil, fmt.Errorf("Cutlist file %s cannot be read: %v", filePath, err)
+	}
+
+	// determine ID
+	id := filePath
+	if clFile, e := ini.InsensitiveLoad(data); e == nil {
+		if sec, e := clFile.GetSection(clSectionMeta); e == nil && sec.Key(clKeyID).Value() != "" {
+			id = sec.Key(clKeyID).Value()
+		}
+	}
+
+	if cl, err = parseCutlist(id, data); err != nil {
+		return nil, err
+	}
+	cl.src = filePath
+
+	return cl, nil
+}
+
+// matchesCutlistFile checks if the local cutlist file filePath belongs to
+// the video. This is the case if the cutlist file is named after the key or
+// the decoded file of the video (i.e. "<key>.cutlist" or "<key>.avi.cutlist"),
+// or if the file the cutlist has been created for (key "ApplyToFile") is a
+// file of the video. Other names that start with the key (e.g. cutlist files
+// of other qualities like "<key>.HQ.avi.cutlist" for SD videos) don't match
+func (v *video) matchesCutlistFile(filePath string) bool {
+	name := filepath.Base(filePath)
+	if name == v.key+clFileSuffix || name == v.ri.fileName(v.ri.quality)+clFileSuffix {
+		return true
+	}
+
+	// check ApplyToFile
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return false
+	}
+	clFile, err := ini.InsensitiveLoad(data)
+	if err != nil {
+		return false
+	}
+	sec, err := clFile.GetSection(clSectionGeneral)
+	if err != nil {
+		return false
+	}
+	return v.isFileOfVideo(sec.Key(clKeyApplyTo).Value())
+}
+
+// isFileOfVideo checks if fileName is the name of a (encoded, decoded or
+// cut) file of the video. Since SD and mp4 videos have the same key, the
+// quality must fit as well. Only for cut files, the quality is not checked,
+// as SD and mp4 videos cannot be distinguished anymore once they have been
+// cut into mkv files
+func (v *video) isFileOfVideo(fileName string) bool {
+	if fileName == "" {
+		return false
+	}
+	key, ri, _, status, err := analyzeFile(filepath.Base(fileName))
+	if err != nil || key != v.key {
+		return false
+	}
+	return status == vidStatusCut || ri.quality == v.ri.quality
+}
+
+// findCutlistFiles searches for local cutlist files of the video in the
+// directories dirs and stores their paths in the video
+func (v *video) findCutlistFiles(dirs []string) {
+	for _, dir := range dirs {
+		filePaths, err := filepath.Glob(filepath.Join(dir, "*"+clFileSuffix))
+		if err != nil {
+			continue
+		}
+		for _, filePath := range filePaths {
+			if v.matchesCutlistFile(filePath) {
+				v.addCutlistFile(filePath)
+			}
+		}
+	}
+}
+
+// addCutlistFile adds a local cutlist file to the video (if it hasn't been
+// added before)
+func (v *video) addCutlistFile(filePath string) {
+	filePath, _ = filepath.Abs(filePath)
+	for _, fp := range v.clFiles {
+		if fp == filePath {
+			return
+		}
+	}
+	log.WithFields(log.Fields{"key": v.key}).Infof("Found local cutlist %s", filePath)
+	v.clFiles = append(v.clFiles, filePath)
+}
+
+// loadLocalCutlist loops at the local cutlist files of the video and returns
+// the first one that can be parsed and that hasn't been rejected in a
+// preview. If there's none, nil is returned
+func (v *video) loadLocalCutlist() *cutlist {
+	rec := readPreviewRec(v.key)
+	for _, filePath := range v.clFiles {
+		// skip cutlists that have been rejected in a preview
+		if rec.isRejected(filePath) {
+			log.WithFields(log.Fields{"key": v.key}).Infof("Local cutlist %s has been rejected in preview", filePath)
+			continue
+		}
+		cl, err := readCutlistFile(filePath)
+		if err != nil {
+			log.WithFields(log.Fields{"key": v.key}).Error(err.Error())
+			continue
+		}
+		cl.reason = fmt.Sprintf("Local cutlist file %s", filePath)
+		return cl
+	}
+	return nil
+}
+
+// assignCutlistFiles assigns the cutlist files that have been passed via
+// command line to the videos of the list. Each spec has the format
+// "[video=]file": If video (a key or a file name of a video) is given, the
+// cutlist file is assigned to that video. Otherwise, it's assigned to the
+// video it has been created for (key "ApplyToFile" or file name), or - if
+// only one video is to be processed - to that video.
+func (vl videoList) assignCutlistFiles(specs []string) error {
+	for _, spec := range specs {
+		var (
+			vid      string
+			filePath = spec
+			v        *video
+		)
+		// keys of videos never contain "=", but paths of cutlist files can.
+		// Thus, the spec is split at the first "=" (unless it's the path of
+		// an existing file)
+		if i := strings.Index(spec, "="); i >= 0 && !exists(spec) {
+			vid, filePath = spec[:i], spec[i+1:]
+		}
+
+		if _, err := ioutil.ReadFile(filePath); err != nil {
+			return fmt.Errorf("Cutlist file %s cannot be read: %v", filePath, err)
+		}
+
+		// find video for cutlist file
+		switch {
+		case vid != "":
+			for _, w := range vl {
+				if w.key == vid || w.isFileOfVideo(vid) {
+					v = w
+					break
+				}
+			}
+		default:
+			var open []*video
+			for _, w := range vl {
+				if w.matchesCutlistFile(filePath) {
+					v = w
+					break
+				}
+				if !w.isDone() {
+					open = append(open, w)
+				}
+			}
+			if v == nil && len(open) == 1 {
+				v = open[0]
+			}
+		}
+		if v == nil {
+			return fmt.Errorf("No video found for cutlist file %s", filePath)
+		}
+
+		// explicitly passed cutlist files have highest priority
+		filePath, _ = filepath.Abs(filePath)
+		log.WithFields(log.Fields{"key": v.key}).Infof("Use cutlist file %s", filePath)
+		v.clFiles = append([]string{filePath}, v.clFiles...)
+	}
+
+	return nil
+}
+
+// format returns the cutlist in the INI format of cutlist files (sections
+// [General], [Info] and [Cut0], [Cut1], ...). Frame numbers are written if
+// the cutlist contains them or if they can be calculated from the frame rate
+func (cl *cutlist) format() []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "[General]\n")
+	fmt.Fprintf(&b, "Application=gool\n")
+	fmt.Fprintf(&b, "Version=%s\n", Version)
+	fmt.Fprintf(&b, "IntendedCutApplicationName=%s\n", cl.app)
+	fmt.Fprintf(&b, "ApplyToFile=%s\n", cl.applyTo)
+	if cl.origSize > 0 {
+		fmt.Fprintf(&b, "OriginalFileSizeBytes=%d\n", cl.origSize)
+	}
+	if cl.fps > 0 {
+		fmt.Fprintf(&b, "FramesPerSecond=%s\n", strconv.FormatFloat(cl.fps, 'f', -1, 64))
+	}
+	fmt.Fprintf(&b, "DisplayAspectRatio=%s\n", cl.ratio)
+	fmt.Fprintf(&b, "NoOfCuts=%d\n", len(cl.segs))
+
+	fmt.Fprintf(&b, "\n[Info]\n")
+	fmt.Fprintf(&b, "Author=%s\n", cl.author)
+	fmt.Fprintf(&b, "ActualContent=%s\n", cl.content)
+	fmt.Fprintf(&b, "SuggestedMovieName=%s\n", cl.movieName)
+	fmt.Fprintf(&b, "UserComment=%s\n", cl.comment)
+
+	for i, sg := range cl.segs {
+		start, end := cl.times(i)
+		fmt.Fprintf(&b, "\n[Cut%d]\n", i)
+		fmt.Fprintf(&b, "Start=%.6f\n", start)
+		fmt.Fprintf(&b, "Duration=%.6f\n", end-start)
+		switch {
+		case cl.frameBased:
+			fmt.Fprintf(&b, "StartFrame=%d\n", sg.frameStart)
+			fmt.Fprintf(&b, "DurationFrames=%d\n", sg.frameDur)
+		case cl.fps > 0:
+			fStart := int(math.Round(start * cl.fps))
+			fmt.Fprintf(&b, "StartFrame=%d\n", fStart)
+			fmt.Fprintf(&b, "DurationFrames=%d\n", int(math.Round(end*cl.fps))-fStart)
+		}
+	}
+
+	return b.Bytes()
+}
+
+// writeCutlistFile writes the cutlist into the cutlist file filePath. An
+// existing file is not overwritten
+func writeCutlistFile(cl *cutlist, filePath string) error {
+	if exists(filePath) {
+		return fmt.Errorf("Cutlist file %s already exists", filePath)
+	}
+	if err := ioutil.WriteFile(filePath, cl.format(), 0644); err != nil {
+		return fmt.Errorf("Cutlist file %s cannot be written: %v", filePath, err)
+	}
+	return nil
+}
+
+// localCutlistPath returns the default path for a new local cutlist file of
+// the video: The cutlist dir (if one is configured) or the directory of the
+// video. The name is derived from the decoded video, so that the cutlist file
+// is found in later runs
+func (v *video) localCutlistPath() string {
+	dir := filepath.Dir(v.filePath)
+	if cfg.clDirPath != "" {
+		dir = cfg.clDirPath
+	}
+	return filepath.Join(dir, filepath.Base(v.decFilePath())+clFileSuffix)
+}
@@ -0,0 +1,156 @@
+// Copyright (C) 2018 Michael Picht
+//
+// This file is part of gool (Online TV Recorder on Linux in Go).
+//
+// gool is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// gool is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with gool. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+// cutrec.go implements records of cut videos. Once a video has been cut, a
+// record is stored in the sub directory "cuts" of the cache directory. It
+// contains the path of the cut video, the name of the source file and the
+// cutlist that has been used. Since cut videos can have arbitrary names (see
+// naming templates), the records are needed to get the metadata of cut
+// videos later on (e.g. to rename them).
+
+import (
+	"bytes"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-ini/ini"
+	log "github.com/sirupsen/logrus"
+)
+
+// Constants for cut records
+const (
+	cacheDirNameCuts = "cuts"
+	cutRecSuffix     = ".ini"
+	cutRecSection    = "cut"
+	cutRecKeyFile    = "file"
+	cutRecKeySource  = "source"
+	cutRecKeyCLID    = "cutlist_id"
+	cutRecKeyCLFile  = "cutlist_file"
+)
+
+// cutRec is the record of a cut video
+type cutRec struct {
+	key      string // key of the video
+	filePath string // path of the cut video
+	source   string // name of the decoded file the video has been cut from
+	clID     string // ID of the cutlist that has been used
+	clFile   string // path of the local cutlist file (if one has been used)
+	recPath  string // path of the record file
+}
+
+// cutRecPath returns the path of the record file for the video key
+func cutRecPath(key string) string {
+	return cfg.cacheDirPath + "/" + cacheDirNameCuts + "/" + url.PathEscape(key) + cutRecSuffix
+}
+
+// writeCutRec stores the record of the video after it has been cut
+func (v *video) writeCutRec() {
+	rec := cutRec{
+		key:      v.key,
+		filePath: v.cutFilePath,
+		source:   v.ri.fileName(v.ri.quality),
+		recPath:  cutRecPath(v.key),
+	}
+	if v.cl != nil {
+		rec.clID = v.cl.id
+		rec.clFile = v.cl.src
+	}
+	if err := rec.write(); err != nil {
+		log.WithFields(log.Fields{"key": v.key}).Errorf("Cut record cannot be written: %v", err)
+	}
+}
+
+// write stores the record in its record file
+func (rec *cutRec) write() error {
+	f := ini.Empty()
+	sec, _ := f.NewSection(cutRecSection)
+	_, _ = sec.NewKey(cutRecKeyFile, rec.filePath)
+	_, _ = sec.NewKey(cutRecKeySource, rec.source)
+	_, _ = sec.NewKey(cutRecKeyCLID, rec.clID)
+	_, _ = sec.NewKey(cutRecKeyCLFile, rec.clFile)
+
+	var b bytes.Buffer
+	if _, err := f.WriteTo(&b); err != nil {
+		return err
+	}
+	return writeFileAtomic(rec.recPath, b.Bytes())
+}
+
+// readCutRecs reads all cut records and returns them as map with the path of
+// the cut video as key
+func readCutRecs() map[string]*cutRec {
+	recs := make(map[string]*cutRec)
+
+	recPaths, _ := filepath.Glob(cfg.cacheDirPath + "/" + cacheDirNameCuts + "/*" + cutRecSuffix)
+	for _, recPath := range recPaths {
+		f, err := ini.Load(recPath)
+		if err != nil {
+			log.Errorf("Cut record %s cannot be read: %v", recPath, err)
+			continue
+		}
+		sec := f.Section(cutRecSection)
+		key, _ := url.PathUnescape(strings.TrimSuffix(filepath.Base(recPath), cutRecSuffix))
+		rec := &cutRec{
+			key:      key,
+			filePath: sec.Key(cutRecKeyFile).Value(),
+			source:   sec.Key(cutRecKeySource).Value(),
+			clID:     sec.Key(cutRecKeyCLID).Value(),
+			clFile:   sec.Key(cutRecKeyCLFile).Value(),
+			recPath:  recPath,
+		}
+		recs[rec.filePath] = rec
+	}
+
+	return recs
+}
+
+// cutRecOf returns the record of the video key. If there's none, nil is
+// returned
+func cutRecOf(key string) *cutRec {
+	for _, rec := range readCutRecs() {
+		if rec.key == key {
+			return rec
+		}
+	}
+	return nil
+}
+
+// cutlist returns the cutlist of the record. It's taken from the local
+// cutlist file or the cache. The cutlist server is not called. If the cutlist
+// is not available, nil is returned
+func (rec *cutRec) cutlist() *cutlist {
+	if rec.clFile != "" {
+		if cl, err := readCutlistFile(rec.clFile); err == nil {
+			return cl
+		}
+	}
+	if rec.clID == "" {
+		return nil
+	}
+	data, ok := readCache(cutlistCachePath(rec.clID), -1)
+	if !ok {
+		return nil
+	}
+	cl, err := parseCutlist(rec.clID, data)
+	if err != nil {
+		return nil
+	}
+	return cl
+}
@@ -28,20 +28,29 @@ import (
 	"path"
 	"regexp"
 	"strconv"
-	"strings"
 	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
-// callOTRDecoder calls otrdecoder and handles the command line output.
-func (v *video) callOTRDecoder() error {
+// number of phases otrdecoder runs through (verification of input file,
+// decoding, verification of output file)
+const otrDecPhases = 3
+
+// reOTRDecPrg matches the progress information (percentages) of otrdecoder
+var reOTRDecPrg = regexp.MustCompile(`(\d+)%`)
+
+// callOTRDecoder calls otrdecoder for the encoded file filePath (either the
+// video or its AC3 audio file) and handles the command line output. act is the
+// action that is used for the progress bar
+func (v *video) callOTRDecoder(act int, filePath string) error {
 	var (
 		err         error
 		errStr      string
 		otrFilePath string
-		prg         int
-		prgSet      int
+		phase       int
+		last        = -1
 	)
 
 	// Create filepath to call otr decoder: If no directory path has been configured ...
@@ -56,7 +65,7 @@ func (v *video) callOTRDecoder() error {
 	cmd := exec.Command(otrFilePath,
 		"-e", cfg.otrUsername,
 		"-p", cfg.otrPassword,
-		"-i", v.filePath,
+		"-i", filePath,
 		"-o", cfg.decDirPath)
 	// print cmd string to log
 	{
@@ -84,23 +93,26 @@ func (v *video) callOTRDecoder() error {
 		return err
 	}
 
-	// read command's stdout line by line
+	// read command's stdout word by word. Only the percentages are evaluated:
+	// otrdecoder processes the file in several phases and reports the progress
+	// of each phase from 0% to 100%. A new phase starts when the percentage
+	// drops. Thus, the progress doesn't depend on the (language specific)
+	// messages of otrdecoder
 	cmdOut := bufio.NewScanner(stdout)
 	cmdOut.Split(bufio.ScanWords)
 	for cmdOut.Scan() {
-		if strings.Contains(cmdOut.Text(), "Dekodiere") || strings.Contains(cmdOut.Text(), "Ausgabe") {
-			prg += 100
+		m := reOTRDecPrg.FindStringSubmatch(cmdOut.Text())
+		if m == nil {
 			continue
 		}
-		if re, _ := regexp.Compile(`\d+%`); re.MatchString(cmdOut.Text()) {
-			n, _ := strconv.Atoi(strings.TrimSuffix(cmdOut.Text(), "%"))
-			if (prg+n/3)-prgSet > 5 || prgSet == 0 {
-				prgSet = (prg + n) / 3
-				v.setPrgBar(prgActDec, prgSet)
-			}
+		n, _ := strconv.Atoi(m[1])
+		if n < last && phase < otrDecPhases-1 {
+			phase++
 		}
+		last = n
+		v.setPrgBar(act, (phase*100+n)/otrDecPhases)
 	}
-	v.setPrgBar(prgActDec, 100)
+	v.setPrgBar(act, 100)
 
 	// read command's stderr line by line and store it in a errStr for further processing
 	cmdErr := bufio.NewScanner(stderr)
@@ -111,7 +123,10 @@ func (v *video) callOTRDecoder() error {
 	if err = cmd.Wait(); err != nil {
 		// In case command line execution returns error, content of stderr (now contained in
 		// errStr) is written into error file
-		errFilePath := cfg.logDirPath + "/" + v.key + path.Ext(v.filePath) + errFileSuffixDec
+		errFilePath := cfg.logDirPath + "/" + v.key + path.Ext(filePath) + errFileSuffixDec
+		if act == prgActDecAC3 {
+			errFilePath = cfg.logDirPath + "/" + v.key + ".ac3" + path.Ext(filePath) + errFileSuffixDec
+		}
 		if errFile, e := os.Create(errFilePath); e != nil {
 			log.WithFields(log.Fields{"key": v.key}).Errorf("Cannot create \"%s\": %v", errFilePath, e)
 		} else {
@@ -137,12 +152,33 @@ func (v *video) decode(wg *sync.WaitGroup, r chan<- res) {
 		return
 	}
 
-	// Call otrdecoder
-	errOTR := v.callOTRDecoder()
+	var errOTR error
+
+	// Call otrdecoder for the video (if it's still encoded) ...
+	if v.status == vidStatusEnc {
+		if info, err := os.Stat(v.filePath); err == nil {
+			v.stats.encSize = info.Size()
+		}
+		start := time.Now()
+		errOTR = v.callOTRDecoder(prgActDec, v.filePath)
+		v.stats.decDur = time.Since(start)
 
-	// Process videos based on error info from decoding go routine
-	if err := v.postProcessing("", errOTR); err != nil {
-		log.WithFields(log.Fields{"key": v.key}).Error(err.Error())
+		// Process videos based on error info from decoding go routine
+		if err := v.postProcessing("", errOTR); err != nil {
+			log.WithFields(log.Fields{"key": v.key}).Error(err.Error())
+		}
+	}
+
+	// ... and for its AC3 audio file (if there's one that's still encoded)
+	if errOTR == nil && v.ac3 != nil && v.ac3.status == vidStatusEnc {
+		start := time.Now()
+		errOTR = v.callOTRDecoder(prgActDecAC3, v.ac3.filePath)
+		v.stats.decDur += time.Since(start)
+
+		// Process audio file based on error info
+		if err := v.postProcessingAC3(errOTR); err != nil {
+			log.WithFields(log.Fields{"key": v.key}).Error(err.Error())
+		}
 	}
 
 	// write error message to channel
@@ -0,0 +1,209 @@
+// Copyright (C) 2018 Michael Picht
+//
+// This file is part of gool (Online TV Recorder on Linux in Go).
+//
+// gool is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// gool is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with gool. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+// filename.go implements the parsing of OTR file names. An OTR file name
+// like "Title_18.01.01_20-15_ard_90_TVOON_DE.mpg.HQ.avi.otrkey" carries the
+// title of the recording, the broadcast date and time, the station, the
+// duration in minutes, the quality and the container format. This metadata
+// is stored in the struct recInfo.
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Constants for the quality of a recording
+const (
+	vidQualitySD  = "SD"  // standard definition (DivX AVI)
+	vidQualityHQ  = "HQ"  // high quality (H.264 AVI)
+	vidQualityHD  = "HD"  // high definition (H.264 AVI)
+	vidQualityMP4 = "mp4" // mp4 container (for mobile devices)
+	vidQualityAC3 = "ac3" // separate AC3 audio track of HD recordings
+)
+
+// Constants for the fixed parts of an OTR file name
+const (
+	otrSuffixTVOON = "_TVOON_DE"
+	otrSuffixMPG   = ".mpg"
+	otrSuffixKey   = ".otrkey"
+	otrInfixCut    = ".cut"
+)
+
+// container formats of OTR files and cut videos. Files with other suffixes
+// (e.g. cutlist files named after the video) are no videos, even if their
+// names follow the OTR naming scheme
+var otrContainerFormats = map[string]bool{"avi": true, "mp4": true, "mkv": true, "ac3": true}
+
+// Layouts of the broadcast date and time in OTR file names
+const (
+	otrDateLayout = "06.01.02"
+	otrTimeLayout = "15-04"
+)
+
+// reOTRFileName splits an OTR file name into its parts: (1) title, (2) broadcast
+// date, (3) broadcast time, (4) station, (5) duration in minutes and (6) the rest
+// (i.e. quality, container format and suffixes like ".otrkey")
+var reOTRFileName = regexp.MustCompile(`^(.+)_(\d{2}\.\d{2}\.\d{2})_(\d{2}-\d{2})_([^_]+)_(\d+)` + otrSuffixTVOON + `\` + otrSuffixMPG + `(\..+)$`)
+
+// recInfo contains the metadata of a recording that is stored in the OTR file name
+type recInfo struct {
+	title   string    // title of the recording (as in file name, i.e. with underscores)
+	start   time.Time // broadcast date and time
+	station string    // TV station
+	dur     int       // duration in minutes
+	quality string    // quality of the recording (SD, HQ, HD, mp4, ac3)
+	cf      string    // container format of the recording (e.g. "avi", "mp4", "ac3")
+}
+
+// parseFileName parses an OTR file name and returns the metadata of the
+// recording. In addition, it returns the status of the file (i.e. whether
+// it's encoded, decoded or cut) and its container format. If fileName is not
+// an OTR file name, an error is returned.
+func parseFileName(fileName string) (*recInfo, string, string, error) {
+	var (
+		ri     recInfo
+		status string
+		cf     string
+		err    error
+	)
+
+	// split file name into its parts
+	m := reOTRFileName.FindStringSubmatch(fileName)
+	if m == nil {
+		return nil, "", "", fmt.Errorf("File %s is no OTR File", fileName)
+	}
+
+	ri.title = m[1]
+	ri.station = m[4]
+
+	// broadcast date and time
+	if ri.start, err = time.ParseInLocation(otrDateLayout+"_"+otrTimeLayout, m[2]+"_"+m[3], time.Local); err != nil {
+		return nil, "", "", fmt.Errorf("Broadcast date/time of %s cannot be interpreted: %v", fileName, err)
+	}
+
+	// duration
+	if ri.dur, err = strconv.Atoi(m[5]); err != nil {
+		return nil, "", "", fmt.Errorf("Duration of %s cannot be interpreted: %v", fileName, err)
+	}
+
+	// determine status from the rest of the file name and remove the
+	// status dependent parts
+	rest := m[6]
+	switch {
+	case strings.HasSuffix(rest, otrSuffixKey):
+		status = vidStatusEnc
+		rest = strings.TrimSuffix(rest, otrSuffixKey)
+	case strings.Contains(rest, otrInfixCut+"."):
+		status = vidStatusCut
+		rest = strings.Replace(rest, otrInfixCut+".", ".", 1)
+	default:
+		status = vidStatusDec
+	}
+
+	// the remaining parts are quality (optional) and container format, e.g.
+	// ".HQ.avi", ".avi", ".mp4" or ".HD.ac3"
+	parts := strings.Split(strings.TrimPrefix(rest, "."), ".")
+	cf = parts[len(parts)-1]
+	if !otrContainerFormats[strings.ToLower(cf)] {
+		return nil, "", "", fmt.Errorf("File %s is no OTR video: Container format %s is not supported", fileName, cf)
+	}
+	switch len(parts) {
+	case 1:
+		ri.cf = cf
+		switch cf {
+		case "mp4":
+			ri.quality = vidQualityMP4
+		default:
+			ri.quality = vidQualitySD
+		}
+	case 2:
+		ri.quality = parts[0]
+		ri.cf = cf
+		if cf == "ac3" {
+			ri.quality = vidQualityAC3
+		}
+		if ri.quality != vidQualityHQ && ri.quality != vidQualityHD && ri.quality != vidQualityAC3 {
+			return nil, "", "", fmt.Errorf("Quality of %s cannot be interpreted", fileName)
+		}
+	default:
+		return nil, "", "", fmt.Errorf("Quality and container format of %s cannot be interpreted", fileName)
+	}
+
+	// for cut videos, the container format of the recording is not known
+	// anymore. It's derived from the quality
+	if status == vidStatusCut {
+		switch ri.quality {
+		case vidQualityMP4:
+			ri.cf = "mp4"
+		case vidQualityAC3:
+			ri.cf = "ac3"
+		default:
+			ri.cf = "avi"
+		}
+	}
+
+	return &ri, status, cf, nil
+}
+
+// base returns the part of the OTR file name that is common to all qualities
+// of a recording, i.e. "Title_18.01.01_20-15_ard_90_TVOON_DE"
+func (ri *recInfo) base() string {
+	return fmt.Sprintf("%s_%s_%s_%s_%d%s",
+		ri.title,
+		ri.start.Format(otrDateLayout),
+		ri.start.Format(otrTimeLayout),
+		ri.station,
+		ri.dur,
+		otrSuffixTVOON)
+}
+
+// key returns the video key (= file name without container format and without
+// suffixes like ".otrkey") of the recording for a given quality
+func (ri *recInfo) key(quality string) string {
+	switch quality {
+	case vidQualitySD, vidQualityMP4:
+		return ri.base() + otrSuffixMPG
+	case vidQualityAC3:
+		return ri.base() + otrSuffixMPG + "." + vidQualityHD
+	default:
+		return ri.base() + otrSuffixMPG + "." + quality
+	}
+}
+
+// fileName returns the name of the decoded file of the recording for a given
+// quality, e.g. "Title_18.01.01_20-15_ard_90_TVOON_DE.mpg.HQ.avi"
+func (ri *recInfo) fileName(quality string) string {
+	switch quality {
+	case vidQualityMP4:
+		return ri.key(quality) + ".mp4"
+	case vidQualityAC3:
+		return ri.key(quality) + ".ac3"
+	default:
+		return ri.key(quality) + ".avi"
+	}
+}
+
+// prettyTitle returns the title of the recording with blanks instead of
+// underscores
+func (ri *recInfo) prettyTitle() string {
+	return strings.TrimSpace(strings.Replace(ri.title, "_", " ", -1))
+}
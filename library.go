@@ -0,0 +1,388 @@
+// Copyright (C) 2018 Michael Picht
+//
+// This file is part of gool (Online TV Recorder on Linux in Go).
+//
+// gool is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// gool is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with gool. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+// library.go implements the export of cut videos into a media server library
+// (e.g. for Kodi, Jellyfin or Plex). If a library root is configured (section
+// [library] of gool.conf), cut videos are classified as movie or episode and
+// moved into the library:
+// - movies:   <root>/Movies/<Movie> (<Year>)/<Movie> (<Year>).mkv
+// - episodes: <root>/TV Shows/<Show>/Season <XX>/<Show> - S<XX>E<YY>.mkv
+// Episodes without season and episode number are named by their air date.
+// In addition, an NFO file with title, station, air date and plot (from the
+// cutlist comment) is written for each video.
+// The classification is done with user defined rules (regular expressions)
+// first. If no rule matches, the actual content of the cutlist is checked for
+// season and episode numbers. Otherwise, long recordings are movies and short
+// ones are episodes.
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/go-ini/ini"
+	log "github.com/sirupsen/logrus"
+)
+
+// Constants for the library configuration
+const (
+	cfgSectionLib      = "library"
+	cfgKeyLibRoot      = "root"
+	cfgKeyLibMovieDur  = "movie_min_duration"
+	cfgKeyLibRulePrefx = "rule"
+)
+
+// Constants for the library layout
+const (
+	libDirNameMovies = "Movies"
+	libDirNameShows  = "TV Shows"
+	libNFOSuffix     = ".nfo"
+	libShowNFOName   = "tvshow.nfo"
+)
+
+// Constants for the types of library items
+const (
+	libTypeMovie   = "movie"
+	libTypeEpisode = "episode"
+)
+
+// Names of the groups that library rules can use
+const (
+	libGroupShow    = "show"
+	libGroupTitle   = "title"
+	libGroupSeason  = "season"
+	libGroupEpisode = "episode"
+	libGroupYear    = "year"
+)
+
+// reLibEpisode matches season and episode numbers in the actual content of
+// cutlists (e.g. "S02E05", "2x05" or "Staffel 2, Folge 5")
+var reLibEpisode = regexp.MustCompile(`(?i)(?:s(\d{1,2})\s*e(\d{1,3})|\b(\d{1,2})x(\d{1,3})\b|staffel\s*(\d{1,2})\D{1,10}folge\s*(\d{1,3}))`)
+
+// libRule is a user defined rule for the classification of recordings. The
+// regular expression is applied to the title of the recording (with blanks)
+// and - separated by " - " - the actual content of the cutlist. Named groups
+// (show, title, season, episode, year) are used as metadata
+type libRule struct {
+	typ string         // type of library item (movie or episode)
+	re  *regexp.Regexp // regular expression
+}
+
+// libItem contains the metadata of a video in the library
+type libItem struct {
+	typ     string // movie or episode
+	show    string // name of the show (episodes only)
+	title   string // title of the movie or episode
+	season  int    // season number (episodes only, 0 if unknown)
+	episode int    // episode number (episodes only, 0 if unknown)
+	year    string // year
+	aired   string // broadcast date (YYYY-MM-DD)
+	station string // TV station
+	plot    string // plot (from cutlist comment)
+}
+
+// readLibConfig reads the section [library] of the configuration file. The
+// rules are stored in keys with the prefix "rule" (e.g. "rule1"). They have
+// the format "<type>:<regular expression>"
+func (cfg *config) readLibConfig(cfgFile *ini.File, hasChanged *bool) error {
+	var (
+		err error
+		sec *ini.Section
+		key *ini.Key
+	)
+
+	// Get LIBRARY section. If it doesn't exist: Create it.
+	if sec, err = getSection(cfgFile, cfgSectionLib, hasChanged); err != nil {
+		return err
+	}
+
+	// Read ROOT key. If it doesn't exist: Create it with empty value.
+	if key, err = getOptKey(sec, cfgKeyLibRoot, "", hasChanged); err != nil {
+		return err
+	}
+	cfg.libRootPath = key.Value()
+
+	// Read MOVIE_MIN_DURATION key. If it doesn't exist: Create it with default value.
+	if key, err = getOptKey(sec, cfgKeyLibMovieDur, "75", hasChanged); err != nil {
+		return err
+	}
+	cfg.libMovieDur = key.MustInt(75)
+
+	// Read rules (sorted by key name)
+	names := sec.KeyStrings()
+	sort.Strings(names)
+	cfg.libRules = nil
+	for _, name := range names {
+		if !strings.HasPrefix(name, cfgKeyLibRulePrefx) {
+			continue
+		}
+		val := sec.Key(name).Value()
+		tr := strings.SplitN(val, ":", 2)
+		if len(tr) != 2 || (tr[0] != libTypeMovie && tr[0] != libTypeEpisode) {
+			return fmt.Errorf("Library rule %s='%s' has not the format %s|%s:<regular expression>", name, val, libTypeMovie, libTypeEpisode)
+		}
+		re, err := regexp.Compile(tr[1])
+		if err != nil {
+			return fmt.Errorf("Library rule %s contains an invalid regular expression: %v", name, err)
+		}
+		cfg.libRules = append(cfg.libRules, libRule{typ: tr[0], re: re})
+	}
+
+	return nil
+}
+
+// classify determines the library metadata for a recording (ri) and its
+// cutlist (cl, can be nil)
+func classify(ri *recInfo, cl *cutlist) *libItem {
+	it := &libItem{
+		show:    ri.prettyTitle(),
+		title:   ri.prettyTitle(),
+		station: ri.station,
+	}
+	if !ri.start.IsZero() {
+		it.year = ri.start.Format("2006")
+		it.aired = ri.start.Format("2006-01-02")
+	}
+	var content string
+	if cl != nil {
+		if cl.movieName != "" {
+			it.title = cl.movieName
+		}
+		content = cl.content
+		it.plot = cl.comment
+	}
+
+	// apply user defined rules
+	subject := ri.prettyTitle()
+	if content != "" {
+		subject += " - " + content
+	}
+	for _, r := range cfg.libRules {
+		m := r.re.FindStringSubmatch(subject)
+		if m == nil {
+			continue
+		}
+		it.typ = r.typ
+		for i, name := range r.re.SubexpNames() {
+			if m[i] == "" {
+				continue
+			}
+			switch name {
+			case libGroupShow:
+				it.show = strings.TrimSpace(m[i])
+			case libGroupTitle:
+				it.title = strings.TrimSpace(m[i])
+			case libGroupSeason:
+				it.season, _ = strconv.Atoi(m[i])
+			case libGroupEpisode:
+				it.episode, _ = strconv.Atoi(m[i])
+			case libGroupYear:
+				it.year = m[i]
+			}
+		}
+		if it.typ == libTypeEpisode && it.title == ri.prettyTitle() && content != "" {
+			it.title = content
+		}
+		return it
+	}
+
+	// actual content contains season and episode numbers: episode
+	if m := reLibEpisode.FindStringSubmatch(content); m != nil {
+		it.typ = libTypeEpisode
+		for i := 1; i < len(m); i += 2 {
+			if m[i] != "" {
+				it.season, _ = strconv.Atoi(m[i])
+				it.episode, _ = strconv.Atoi(m[i+1])
+				break
+			}
+		}
+		it.title = content
+		return it
+	}
+
+	// long recordings are movies, short ones episodes
+	if ri.dur >= cfg.libMovieDur {
+		it.typ = libTypeMovie
+	} else {
+		it.typ = libTypeEpisode
+		if content != "" {
+			it.title = content
+		}
+	}
+	return it
+}
+
+// relPath returns the path (relative to the library root, without suffix) of
+// the library item
+func (it *libItem) relPath() string {
+	if it.typ == libTypeMovie {
+		name := sanitizeFileName(fmt.Sprintf("%s (%s)", it.title, it.year))
+		return filepath.Join(libDirNameMovies, name, name)
+	}
+
+	show := sanitizeFileName(it.show)
+	// the episode title is only appended if it differs from the show
+	title := ""
+	if it.title != it.show {
+		title = " - " + it.title
+	}
+	// episodes without numbers are named by air date. The year is used as
+	// season in that case
+	if it.season == 0 && it.episode == 0 {
+		return filepath.Join(libDirNameShows, show, "Season "+it.year, sanitizeFileName(fmt.Sprintf("%s - %s%s", show, it.aired, title)))
+	}
+	return filepath.Join(libDirNameShows, show, fmt.Sprintf("Season %02d", it.season), sanitizeFileName(fmt.Sprintf("%s - S%02dE%02d%s", show, it.season, it.episode, title)))
+}
+
+// NFO structures (see https://kodi.wiki/view/NFO_files)
+type nfoMovie struct {
+	XMLName   xml.Name `xml:"movie"`
+	Title     string   `xml:"title"`
+	Year      string   `xml:"year,omitempty"`
+	Plot      string   `xml:"plot,omitempty"`
+	Studio    string   `xml:"studio,omitempty"`
+	Premiered string   `xml:"premiered,omitempty"`
+}
+type nfoEpisode struct {
+	XMLName   xml.Name `xml:"episodedetails"`
+	Title     string   `xml:"title"`
+	ShowTitle string   `xml:"showtitle"`
+	Season    int      `xml:"season,omitempty"`
+	Episode   int      `xml:"episode,omitempty"`
+	Aired     string   `xml:"aired,omitempty"`
+	Plot      string   `xml:"plot,omitempty"`
+	Studio    string   `xml:"studio,omitempty"`
+}
+type nfoShow struct {
+	XMLName xml.Name `xml:"tvshow"`
+	Title   string   `xml:"title"`
+	Studio  string   `xml:"studio,omitempty"`
+}
+
+// writeNFO writes the NFO file for the library item whose video is stored in
+// vidPath. For episodes, the NFO file of the show is written as well (if it
+// doesn't exist yet)
+func (it *libItem) writeNFO(vidPath string) error {
+	var nfo interface{}
+	if it.typ == libTypeMovie {
+		nfo = nfoMovie{Title: it.title, Year: it.year, Plot: it.plot, Studio: it.station, Premiered: it.aired}
+	} else {
+		nfo = nfoEpisode{Title: it.title, ShowTitle: it.show, Season: it.season, Episode: it.episode, Aired: it.aired, Plot: it.plot, Studio: it.station}
+		showNFOPath := filepath.Join(filepath.Dir(filepath.Dir(vidPath)), libShowNFOName)
+		if !exists(showNFOPath) {
+			if err := writeXMLFile(showNFOPath, nfoShow{Title: it.show, Studio: it.station}); err != nil {
+				return err
+			}
+		}
+	}
+	return writeXMLFile(strings.TrimSuffix(vidPath, filepath.Ext(vidPath))+libNFOSuffix, nfo)
+}
+
+// writeXMLFile writes v as XML document into the file filePath
+func writeXMLFile(filePath string, v interface{}) error {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append([]byte(xml.Header), append(data, '\n')...)
+	return ioutil.WriteFile(filePath, data, 0644)
+}
+
+// moveFile moves the file src to dst. The library is often located on
+// another file system than the working dir (e.g. on a NAS). In that case, the
+// file cannot be renamed but is copied into a temporary file next to dst,
+// synced to disk and renamed to dst. Finally, src is removed. The
+// modification time of src is kept
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if le, ok := err.(*os.LinkError); !ok || le.Err != syscall.EXDEV {
+		return err
+	}
+
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	out, err := ioutil.TempFile(filepath.Dir(dst), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err == nil {
+		err = out.Sync()
+	}
+	if e := out.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Chmod(out.Name(), info.Mode())
+	}
+	if err == nil {
+		err = os.Chtimes(out.Name(), info.ModTime(), info.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), dst)
+	}
+	if err != nil {
+		_ = os.Remove(out.Name())
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+// exportToLibrary moves the cut video into the library and writes the NFO
+// file(s). The path of the video is updated accordingly
+func (v *video) exportToLibrary() error {
+	it := classify(v.ri, v.cl)
+	rel := it.relPath()
+	dir := filepath.Join(cfg.libRootPath, filepath.Dir(rel))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Library directory %s cannot be created: %v", dir, err)
+	}
+
+	dst, err := reservePath(dir, filepath.Base(rel), filepath.Ext(v.cutFilePath))
+	if err != nil {
+		return err
+	}
+	if err = moveFile(v.cutFilePath, dst); err != nil {
+		return fmt.Errorf("%s cannot be moved to %s: %v", v.cutFilePath, dst, err)
+	}
+	log.WithFields(log.Fields{"key": v.key}).Infof("Video has been exported as %s to %s", it.typ, dst)
+	v.cutFilePath = dst
+	v.filePath = dst
+
+	if err = it.writeNFO(dst); err != nil {
+		return fmt.Errorf("NFO file for %s cannot be written: %v", dst, err)
+	}
+
+	return nil
+}
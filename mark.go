@@ -0,0 +1,282 @@
+// Copyright (C) 2018 Michael Picht
+//
+// This file is part of gool (Online TV Recorder on Linux in Go).
+//
+// gool is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// gool is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with gool. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+// mark.go implements the non-destructive mode: Instead of cutting a video,
+// the segments of its cutlist are written into skip files next to the decoded
+// video. The video itself is not changed. The skip files are:
+// - an EDL file for Kodi (<name>.edl) that marks the ad breaks as commercials
+// - an EDL file for mpv (<name>.mpv.edl) that only plays the segments
+// - a Matroska chapter file (<name>.chapters.xml) with chapters at the start
+//   of each segment and of each ad break
+// Videos with skip files have the status "marked" (MRK). Since files with
+// these names can also be created by other programs (e.g. comskip) or by the
+// user, gool stores a record in the sub directory "marks" of the cache
+// directory for each video it has marked. Only skip files that are recorded
+// there are taken into account and removed by gool.
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-ini/ini"
+	log "github.com/sirupsen/logrus"
+)
+
+// suffices of the skip files
+const (
+	markSuffixKodi = ".edl"
+	markSuffixMPV  = ".mpv.edl"
+	markSuffixChap = ".chapters.xml"
+)
+
+// Constants for mark records
+const (
+	cacheDirNameMarks = "marks"
+	markRecSuffix     = ".ini"
+	markRecSection    = "mark"
+	markRecKeyVideo   = "video"
+)
+
+// action of ad breaks in Kodi EDL files (3 = commercial break)
+const markKodiActionComm = 3
+
+// header of mpv EDL files
+const markMPVHeader = "# mpv EDL v0"
+
+// markBase returns the path of the decoded video filePath without its
+// container format. The suffices of the skip files are appended to it
+func markBase(filePath string) string {
+	return strings.TrimSuffix(filePath, filepath.Ext(filePath))
+}
+
+// markFilePaths returns the paths of the skip files of the video filePath
+func markFilePaths(filePath string) []string {
+	base := markBase(filePath)
+	return []string{base + markSuffixKodi, base + markSuffixMPV, base + markSuffixChap}
+}
+
+// markRecPath returns the path of the mark record of the video key
+func markRecPath(key string) string {
+	return cfg.cacheDirPath + "/" + cacheDirNameMarks + "/" + url.PathEscape(key) + markRecSuffix
+}
+
+// markedFilePath returns the path of the decoded video that gool has written
+// skip files for (according to the mark record of the video key). If there's
+// no mark record, an empty string is returned
+func markedFilePath(key string) string {
+	recPath := markRecPath(key)
+	if !exists(recPath) {
+		return ""
+	}
+	f, err := ini.Load(recPath)
+	if err != nil {
+		log.WithFields(log.Fields{"key": key}).Errorf("Mark record %s cannot be read: %v", recPath, err)
+		return ""
+	}
+	return f.Section(markRecSection).Key(markRecKeyVideo).Value()
+}
+
+// writeMarkRec stores that gool has written skip files for the decoded video
+// filePath of the video key
+func writeMarkRec(key, filePath string) error {
+	f := ini.Empty()
+	sec, _ := f.NewSection(markRecSection)
+	_, _ = sec.NewKey(markRecKeyVideo, filepath.Clean(filePath))
+
+	var b bytes.Buffer
+	if _, err := f.WriteTo(&b); err != nil {
+		return err
+	}
+	return writeFileAtomic(markRecPath(key), b.Bytes())
+}
+
+// isMarked checks if gool has written skip files for the decoded video
+// filePath of the video key and if its Kodi EDL file still exists, i.e. if
+// the video has been marked
+func isMarked(key, filePath string) bool {
+	return markedFilePath(key) == filepath.Clean(filePath) && exists(markBase(filePath)+markSuffixKodi)
+}
+
+// fmtSec formats a time (in seconds) for EDL files
+func fmtSec(t float64) string {
+	return strconv.FormatFloat(t, 'f', 3, 64)
+}
+
+// skipRanges returns the ranges (start and end in seconds) that are not
+// contained in the cutlist, i.e. the ad breaks. dur is the duration of the
+// video. If it's 0, the range after the last segment is not returned
+func (cl *cutlist) skipRanges(dur float64) [][2]float64 {
+	var (
+		rs  [][2]float64
+		pos float64
+	)
+	for i := range cl.segs {
+		start, end := cl.times(i)
+		if start > pos {
+			rs = append(rs, [2]float64{pos, start})
+		}
+		if end > pos {
+			pos = end
+		}
+	}
+	if dur > pos {
+		rs = append(rs, [2]float64{pos, dur})
+	}
+	return rs
+}
+
+// kodiEDL returns the content of the Kodi EDL file
+func (v *video) kodiEDL() string {
+	var (
+		s   string
+		dur float64
+	)
+	if v.mi != nil {
+		dur = v.mi.dur
+	}
+	for _, r := range v.cl.skipRanges(dur) {
+		s += fmt.Sprintf("%s\t%s\t%d\n", fmtSec(r[0]), fmtSec(r[1]), markKodiActionComm)
+	}
+	return s
+}
+
+// mpvEDL returns the content of the mpv EDL file. The file name of the video
+// is stored relatively to the EDL file (and with length prefix, so that
+// commas in the file name don't cause trouble)
+func (v *video) mpvEDL() string {
+	name := filepath.Base(v.filePath)
+	s := markMPVHeader + "\n"
+	for i := range v.cl.segs {
+		start, end := v.cl.times(i)
+		s += fmt.Sprintf("%%%d%%%s,%s,%s\n", len(name), name, fmtSec(start), fmtSec(end-start))
+	}
+	return s
+}
+
+// markChapters returns the Matroska chapters of the marked video: A chapter at
+// the start of each segment and at the start of each ad break
+func (v *video) markChapters() mkvChapters {
+	var (
+		chaps mkvChapters
+		dur   float64
+		part  int
+	)
+	if v.mi != nil {
+		dur = v.mi.dur
+	}
+
+	// collect segments and ad breaks in chronological order
+	type chap struct {
+		start float64
+		name  string
+	}
+	var cs []chap
+	for _, r := range v.cl.skipRanges(dur) {
+		cs = append(cs, chap{r[0], "Advertisement"})
+	}
+	for i := range v.cl.segs {
+		start, _ := v.cl.times(i)
+		cs = append(cs, chap{start, ""})
+	}
+	sort.Slice(cs, func(i, j int) bool { return cs[i].start < cs[j].start })
+
+	for _, c := range cs {
+		if c.name == "" {
+			part++
+			c.name = fmt.Sprintf("Part %d", part)
+		}
+		chaps.Atoms = append(chaps.Atoms, mkvChapAtom{TimeStart: timeStr(c.start), String: c.name, Language: mkvChapLanguage})
+	}
+	return chaps
+}
+
+// mark writes the skip files for the video. Existing skip files are only
+// overwritten if they have been written by gool
+func (v *video) mark() error {
+	base := markBase(v.filePath)
+
+	if markedFilePath(v.key) != filepath.Clean(v.filePath) {
+		for _, p := range markFilePaths(v.filePath) {
+			if exists(p) {
+				return fmt.Errorf("%s already exists and hasn't been written by gool", p)
+			}
+		}
+	}
+	if err := writeMarkRec(v.key, v.filePath); err != nil {
+		return fmt.Errorf("Mark record cannot be written: %v", err)
+	}
+
+	if err := ioutil.WriteFile(base+markSuffixKodi, []byte(v.kodiEDL()), 0644); err != nil {
+		return fmt.Errorf("Kodi EDL file cannot be written: %v", err)
+	}
+	if err := ioutil.WriteFile(base+markSuffixMPV, []byte(v.mpvEDL()), 0644); err != nil {
+		return fmt.Errorf("mpv EDL file cannot be written: %v", err)
+	}
+	if err := writeXMLFile(base+markSuffixChap, v.markChapters()); err != nil {
+		return fmt.Errorf("Chapter file cannot be written: %v", err)
+	}
+
+	log.WithFields(log.Fields{"key": v.key}).Infof("Video has been marked: %s", base+markSuffixKodi)
+	return nil
+}
+
+// unmark removes the skip files of the decoded video filePath (e.g. after it
+// has been cut) and the mark record. Skip files that haven't been written by
+// gool are kept
+func (v *video) unmark(filePath string) {
+	if markedFilePath(v.key) != filepath.Clean(filePath) {
+		return
+	}
+	for _, p := range markFilePaths(filePath) {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			log.WithFields(log.Fields{"key": v.key}).Warnf("%s couldn't be deleted: %v", p, err)
+		}
+	}
+	if err := os.Remove(markRecPath(v.key)); err != nil && !os.IsNotExist(err) {
+		log.WithFields(log.Fields{"key": v.key}).Warnf("Mark record couldn't be deleted: %v", err)
+	}
+}
+
+// isDone checks if the video doesn't need to be processed anymore: Either it
+// has been cut already or it has been marked and gool runs in mark mode
+func (v *video) isDone() bool {
+	return v.status == vidStatusCut || (v.status == vidStatusMrk && cfg.markOnly)
+}
+
+// markVideo marks the video instead of cutting it and sets its status and
+// result accordingly
+func (v *video) markVideo() {
+	defer v.setPrgBar(prgActCut, 100)
+
+	if err := v.mark(); err != nil {
+		log.WithFields(log.Fields{"key": v.key}).Error(err.Error())
+		v.writeCutErrFile(err.Error() + "\n")
+		v.res = vidResultErr
+		return
+	}
+	v.res = vidResultOK
+	v.status = vidStatusMrk
+}
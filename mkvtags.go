@@ -0,0 +1,187 @@
+// Copyright (C) 2018 Michael Picht
+//
+// This file is part of gool (Online TV Recorder on Linux in Go).
+//
+// gool is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// gool is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with gool. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+// mkvtags.go implements the metadata that is written into the videos cut by
+// MKVmerge: Global Matroska tags (title, broadcast date, station, source file,
+// cutlist) and - optionally - chapters at the positions where ad breaks have
+// been removed. The tags are passed to MKVmerge as XML file. The chapters are
+// added to the cut video with MKVpropedit, since their positions refer to the
+// timeline of the cut video.
+// In addition, the modification time of cut videos can be set to the
+// broadcast time.
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Constants related to Matroska metadata
+const (
+	mkvpropeditName  = "mkvpropedit"
+	mkvTagsFileName  = "tags.xml"
+	mkvChapsFileName = "chapters.xml"
+	mkvChapLanguage  = "ger"
+	// target type value for global tags that refer to a movie or an episode
+	// (see https://www.matroska.org/technical/tagging.html)
+	mkvTargetMovie = 50
+)
+
+// Names of the Matroska tags written by gool. Tags that are not defined by
+// the Matroska specification have the prefix "OTR_"
+const (
+	mkvTagTitle     = "TITLE"
+	mkvTagSubtitle  = "SUBTITLE"
+	mkvTagDate      = "DATE_RECORDED"
+	mkvTagStation   = "TV_NETWORK"
+	mkvTagComment   = "COMMENT"
+	mkvTagSource    = "OTR_SOURCE_FILE"
+	mkvTagCLID      = "OTR_CUTLIST_ID"
+	mkvTagCLAuthor  = "OTR_CUTLIST_AUTHOR"
+	mkvTagMediaType = "ORIGINAL_MEDIA_TYPE"
+)
+
+// XML structures for Matroska tags
+type mkvTags struct {
+	XMLName xml.Name `xml:"Tags"`
+	Tag     mkvTag   `xml:"Tag"`
+}
+type mkvTag struct {
+	TargetTypeValue int         `xml:"Targets>TargetTypeValue"`
+	Simples         []mkvSimple `xml:"Simple"`
+}
+type mkvSimple struct {
+	Name   string `xml:"Name"`
+	String string `xml:"String"`
+}
+
+// XML structures for Matroska chapters
+type mkvChapters struct {
+	XMLName xml.Name      `xml:"Chapters"`
+	Atoms   []mkvChapAtom `xml:"EditionEntry>ChapterAtom"`
+}
+type mkvChapAtom struct {
+	TimeStart string `xml:"ChapterTimeStart"`
+	String    string `xml:"ChapterDisplay>ChapterString"`
+	Language  string `xml:"ChapterDisplay>ChapterLanguage"`
+}
+
+// mkvTitle returns the title of the cut video: The movie name suggested by
+// the cutlist or - if there's none - the title of the recording
+func (v *video) mkvTitle() string {
+	if v.cl != nil && v.cl.movieName != "" {
+		return v.cl.movieName
+	}
+	return v.ri.prettyTitle()
+}
+
+// mkvTagValues returns the global tags of the cut video as list of name/value
+// pairs. Tags with empty values are omitted
+func (v *video) mkvTagValues() []mkvSimple {
+	var ss []mkvSimple
+
+	add := func(name, val string) {
+		if val != "" {
+			ss = append(ss, mkvSimple{Name: name, String: val})
+		}
+	}
+	add(mkvTagTitle, v.mkvTitle())
+	if v.cl != nil {
+		add(mkvTagSubtitle, v.cl.content)
+	}
+	if !v.ri.start.IsZero() {
+		add(mkvTagDate, v.ri.start.Format("2006-01-02 15:04"))
+	}
+	add(mkvTagStation, v.ri.station)
+	add(mkvTagMediaType, "TV")
+	add(mkvTagSource, v.ri.fileName(v.ri.quality))
+	if v.cl != nil {
+		add(mkvTagCLID, v.cl.id)
+		add(mkvTagCLAuthor, v.cl.author)
+		add(mkvTagComment, v.cl.comment)
+	}
+
+	return ss
+}
+
+// writeMKVTags writes the global tags of the cut video as XML file into the
+// directory dir. It returns the path of the file
+func (v *video) writeMKVTags(dir string) (string, error) {
+	tagsPath := dir + "/" + mkvTagsFileName
+	tags := mkvTags{Tag: mkvTag{TargetTypeValue: mkvTargetMovie, Simples: v.mkvTagValues()}}
+	if err := writeXMLFile(tagsPath, tags); err != nil {
+		return "", fmt.Errorf("Matroska tags cannot be written: %v", err)
+	}
+	return tagsPath, nil
+}
+
+// mkvChapterTimes returns the start times (in seconds) of the chapters of the
+// cut video: One chapter per segment of the cutlist, i.e. a chapter starts
+// wherever an ad break has been removed
+func (cl *cutlist) mkvChapterTimes() []float64 {
+	var (
+		ts  []float64
+		pos float64
+	)
+	for i := range cl.segs {
+		ts = append(ts, pos)
+		start, end := cl.times(i)
+		pos += end - start
+	}
+	return ts
+}
+
+// addMKVChapters adds chapters to the cut video filePath. The chapter file is
+// created in the directory dir
+func (v *video) addMKVChapters(dir, filePath string) error {
+	var chaps mkvChapters
+	for i, t := range v.cl.mkvChapterTimes() {
+		chaps.Atoms = append(chaps.Atoms, mkvChapAtom{
+			TimeStart: timeStr(t),
+			String:    fmt.Sprintf("Part %d", i+1),
+			Language:  mkvChapLanguage,
+		})
+	}
+
+	chapsPath := dir + "/" + mkvChapsFileName
+	if err := writeXMLFile(chapsPath, chaps); err != nil {
+		return fmt.Errorf("Matroska chapters cannot be written: %v", err)
+	}
+
+	cmd := exec.Command(mkvpropeditName, filePath, "--chapters", chapsPath)
+	log.WithFields(log.Fields{"key": v.key}).Infof("Execute %v", cmd.Args)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("Chapters cannot be added to %s: %v: %s", filePath, err, string(out))
+	}
+	return nil
+}
+
+// setMTime sets the modification time of the cut video filePath to the
+// broadcast time
+func (v *video) setMTime(filePath string) {
+	if v.ri.start.IsZero() {
+		return
+	}
+	if err := os.Chtimes(filePath, v.ri.start, v.ri.start); err != nil {
+		log.WithFields(log.Fields{"key": v.key}).Warnf("Modification time of %s cannot be set: %v", filePath, err)
+	}
+}
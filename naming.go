@@ -0,0 +1,244 @@
+// Copyright (C) 2018 Michael Picht
+//
+// This file is part of gool (Online TV Recorder on Linux in Go).
+//
+// gool is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// gool is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with gool. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+// naming.go implements the naming of cut videos. The name is created from a
+// template that is configured in gool.conf (key "name_template" in section
+// [cut]). The template can contain placeholders in curly brackets (e.g.
+// "{title} ({year})") that are replaced by metadata from the OTR file name
+// and from the cutlist. Characters that are not allowed in file names are
+// removed. If a file with the resulting name already exists, the configured
+// collision strategy is applied.
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Default naming template. It creates the same names as former versions of
+// gool, i.e. "<key>.cut"
+const nameTmplDefault = "{key}" + otrInfixCut
+
+// Constants for the collision strategies
+const (
+	nameCollSuffix    = "suffix"    // add a counter to the name (e.g. "Title (2)")
+	nameCollOverwrite = "overwrite" // overwrite the existing file
+	nameCollSkip      = "skip"      // don't create the file
+)
+
+// Constants for the placeholders of naming templates
+const (
+	namePhKey     = "key"     // key of the video
+	namePhTitle   = "title"   // title from the file name (with blanks)
+	namePhMovie   = "movie"   // suggested movie name of the cutlist (or title)
+	namePhContent = "content" // actual content of the cutlist (e.g. episode title)
+	namePhDate    = "date"    // broadcast date (YYYY-MM-DD)
+	namePhYear    = "year"    // broadcast year
+	namePhTime    = "time"    // broadcast time (HH-MM)
+	namePhStation = "station" // TV station
+	namePhQuality = "quality" // quality (SD, HQ, HD, mp4)
+	namePhDur     = "dur"     // duration in minutes
+	namePhCutlist = "cutlist" // ID of the cutlist
+)
+
+// paths that have been reserved for files that are created in this run of
+// gool. Videos are cut concurrently. Thus, a path can be taken even if no file
+// exists there yet
+var (
+	reservedPaths     = make(map[string]bool)
+	reservedPathsLock sync.Mutex
+)
+
+// reNamePh matches the placeholders of a naming template
+var reNamePh = regexp.MustCompile(`\{(\w+)\}`)
+
+// reNameBlanks matches sequences of white space
+var reNameBlanks = regexp.MustCompile(`\s+`)
+
+// maximum length (in bytes) of a file name (without suffix)
+const nameMaxLen = 200
+
+// checkNameTemplate checks if the naming template only contains known
+// placeholders
+func checkNameTemplate(tmpl string) error {
+	known := nameFields(&recInfo{}, nil, "")
+	for _, m := range reNamePh.FindAllStringSubmatch(tmpl, -1) {
+		if _, ok := known[m[1]]; !ok {
+			var phs []string
+			for ph := range known {
+				phs = append(phs, "{"+ph+"}")
+			}
+			sort.Strings(phs)
+			return fmt.Errorf("Naming template '%s' contains unknown placeholder %s. Use one of %s", tmpl, m[0], strings.Join(phs, ", "))
+		}
+	}
+	return nil
+}
+
+// checkNameCollision checks if the collision strategy is supported
+func checkNameCollision(strategy string) error {
+	switch strategy {
+	case nameCollSuffix, nameCollOverwrite, nameCollSkip:
+		return nil
+	}
+	return fmt.Errorf("Collision strategy '%s' is not supported. Use one of %s, %s, %s", strategy, nameCollSuffix, nameCollOverwrite, nameCollSkip)
+}
+
+// nameFields returns the values of the placeholders for a recording (ri) and
+// its cutlist (cl, can be nil)
+func nameFields(ri *recInfo, cl *cutlist, key string) map[string]string {
+	f := map[string]string{
+		namePhKey:     key,
+		namePhTitle:   ri.prettyTitle(),
+		namePhMovie:   ri.prettyTitle(),
+		namePhContent: "",
+		namePhDate:    "",
+		namePhYear:    "",
+		namePhTime:    "",
+		namePhStation: ri.station,
+		namePhQuality: ri.quality,
+		namePhDur:     "",
+		namePhCutlist: "",
+	}
+	if !ri.start.IsZero() {
+		f[namePhDate] = ri.start.Format("2006-01-02")
+		f[namePhYear] = ri.start.Format("2006")
+		f[namePhTime] = ri.start.Format("15-04")
+	}
+	if ri.dur > 0 {
+		f[namePhDur] = fmt.Sprintf("%d", ri.dur)
+	}
+	if cl != nil {
+		if cl.movieName != "" {
+			f[namePhMovie] = cl.movieName
+		}
+		f[namePhContent] = cl.content
+		f[namePhCutlist] = cl.id
+	}
+	return f
+}
+
+// expandNameTemplate replaces the placeholders of the template by the values
+// of fields and sanitizes the result
+func expandNameTemplate(tmpl string, fields map[string]string) string {
+	return sanitizeFileName(reNamePh.ReplaceAllStringFunc(tmpl, func(ph string) string {
+		return fields[strings.Trim(ph, "{}")]
+	}))
+}
+
+// sanitizeFileName removes characters that are not allowed (or cause trouble)
+// in file names: Slashes and colons are replaced by dashes, other special
+// characters and control characters are removed. Sequences of white space are
+// replaced by one blank. Leading and trailing blanks, dots and dashes (that
+// can result from empty placeholders) are removed.
+func sanitizeFileName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\':
+			return '-'
+		case r == ':':
+			return '-'
+		case strings.ContainsRune(`*?"<>|`, r):
+			return -1
+		case unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, s)
+	s = reNameBlanks.ReplaceAllString(s, " ")
+	s = strings.Trim(s, " .-_")
+	s = strings.Replace(s, "( )", "", -1)
+	s = strings.Replace(s, "()", "", -1)
+	s = strings.Trim(s, " .-_")
+
+	// shorten name if necessary (without splitting multi byte characters)
+	if len(s) > nameMaxLen {
+		n := nameMaxLen
+		for n > 0 && (s[n]&0xC0) == 0x80 {
+			n--
+		}
+		s = strings.TrimSpace(s[:n])
+	}
+	return s
+}
+
+// cutFileName returns the name (without suffix) of the cut video, based on
+// the configured naming template. If the template results in an empty
+// name, the default name is used
+func (v *video) cutFileName() string {
+	name := expandNameTemplate(cfg.nameTmpl, nameFields(v.ri, v.cl, v.key))
+	if name == "" {
+		name = v.key + otrInfixCut
+	}
+	return name
+}
+
+// resolveCollision applies the collision strategy to the file path dir/name
+// + suffix. It returns the path that shall be used. taken checks if a path is
+// already occupied (by an existing file, for instance). If the path is taken
+// and the strategy is "skip", an error is returned.
+func resolveCollision(dir, name, suffix, strategy string, taken func(string) bool) (string, error) {
+	filePath := filepath.Join(dir, name+suffix)
+	if !taken(filePath) {
+		return filePath, nil
+	}
+
+	switch strategy {
+	case nameCollOverwrite:
+		return filePath, nil
+	case nameCollSkip:
+		return "", fmt.Errorf("%s already exists", filePath)
+	}
+
+	// strategy "suffix": add a counter to the name
+	for i := 2; ; i++ {
+		filePath = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", name, i, suffix))
+		if !taken(filePath) {
+			return filePath, nil
+		}
+	}
+}
+
+// reservePath applies the configured collision strategy to the file path
+// dir/name + suffix and reserves the resulting path. A path is taken if a
+// file exists there or if it has been reserved before. Reserved paths are
+// never overwritten (not even with the strategy "overwrite"), since they
+// belong to other videos of the same run. In that case, a counter is added
+func reservePath(dir, name, suffix string) (string, error) {
+	reservedPathsLock.Lock()
+	defer reservedPathsLock.Unlock()
+
+	strategy := cfg.nameColl
+	if strategy == nameCollOverwrite && reservedPaths[filepath.Join(dir, name+suffix)] {
+		strategy = nameCollSuffix
+	}
+	filePath, err := resolveCollision(dir, name, suffix, strategy, func(p string) bool {
+		return reservedPaths[p] || exists(p)
+	})
+	if err != nil {
+		return "", err
+	}
+	reservedPaths[filePath] = true
+
+	return filePath, nil
+}
@@ -0,0 +1,331 @@
+// Copyright (C) 2018 Michael Picht
+//
+// This file is part of gool (Online TV Recorder on Linux in Go).
+//
+// gool is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// gool is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with gool. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+// preview.go implements the preview of cutlists (sub command "preview"): An
+// mpv EDL file is created that plays a few seconds around the start and the
+// end of each segment of the decoded video. Thus, the boundaries of the cuts
+// can be checked before the video is cut. Afterwards, the user can accept or
+// reject the cutlist. The decision is stored in the sub directory "previews"
+// of the cache directory and is taken into account by the next run of
+// "gool process": An accepted cutlist is used for the video, rejected
+// cutlists are not used.
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-ini/ini"
+	log "github.com/sirupsen/logrus"
+)
+
+// Constants for the preview
+const (
+	mpvName              = "mpv"
+	previewWindowDefault = 5 // seconds before and after each cut
+)
+
+// Constants for preview records
+const (
+	cacheDirNamePreviews = "previews"
+	previewRecSuffix     = ".ini"
+	previewRecSection    = "preview"
+	previewRecKeyAcc     = "accepted"
+	previewRecKeyRej     = "rejected"
+)
+
+// previewRec stores the decisions that the user has made for the cutlists of
+// a video in previews
+type previewRec struct {
+	accepted string   // ID of the accepted cutlist (path for local cutlists)
+	rejected []string // IDs of the rejected cutlists (paths for local cutlists)
+}
+
+// previewRecPath returns the path of the preview record of the video key
+func previewRecPath(key string) string {
+	return cfg.cacheDirPath + "/" + cacheDirNamePreviews + "/" + url.PathEscape(key) + previewRecSuffix
+}
+
+// readPreviewRec reads the preview record of the video key. If there's none,
+// an empty record is returned
+func readPreviewRec(key string) *previewRec {
+	rec := new(previewRec)
+	recPath := previewRecPath(key)
+	if !exists(recPath) {
+		return rec
+	}
+	f, err := ini.Load(recPath)
+	if err != nil {
+		log.WithFields(log.Fields{"key": key}).Errorf("Preview record %s cannot be read: %v", recPath, err)
+		return rec
+	}
+	sec := f.Section(previewRecSection)
+	rec.accepted = sec.Key(previewRecKeyAcc).Value()
+	for _, id := range strings.Split(sec.Key(previewRecKeyRej).Value(), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			rec.rejected = append(rec.rejected, id)
+		}
+	}
+	return rec
+}
+
+// write stores the preview record of the video key
+func (rec *previewRec) write(key string) error {
+	f := ini.Empty()
+	sec, _ := f.NewSection(previewRecSection)
+	_, _ = sec.NewKey(previewRecKeyAcc, rec.accepted)
+	_, _ = sec.NewKey(previewRecKeyRej, strings.Join(rec.rejected, ","))
+
+	var b bytes.Buffer
+	if _, err := f.WriteTo(&b); err != nil {
+		return err
+	}
+	return writeFileAtomic(previewRecPath(key), b.Bytes())
+}
+
+// isRejected checks if the cutlist with the ID id has been rejected
+func (rec *previewRec) isRejected(id string) bool {
+	for _, r := range rec.rejected {
+		if r == id {
+			return true
+		}
+	}
+	return false
+}
+
+// accept stores that the cutlist with the ID id has been accepted
+func (rec *previewRec) accept(id string) {
+	rec.accepted = id
+	var rej []string
+	for _, r := range rec.rejected {
+		if r != id {
+			rej = append(rej, r)
+		}
+	}
+	rec.rejected = rej
+}
+
+// reject stores that the cutlist with the ID id has been rejected
+func (rec *previewRec) reject(id string) {
+	if rec.accepted == id {
+		rec.accepted = ""
+	}
+	if !rec.isRejected(id) {
+		rec.rejected = append(rec.rejected, id)
+	}
+}
+
+// rejectPreviewed flags the cutlist headers that have been rejected by the
+// user in a preview as rejected
+func (v *video) rejectPreviewed(clhs clHeaders) {
+	rec := readPreviewRec(v.key)
+	for _, clh := range clhs {
+		if rec.isRejected(clh.id) {
+			clh.rejected = true
+			clh.reason = "rejected in preview"
+		}
+	}
+}
+
+// loadAcceptedCutlist loads the cutlist that has been accepted by the user in
+// a preview. If there's none (or it cannot be loaded), nil is returned
+func (v *video) loadAcceptedCutlist() *cutlist {
+	id := readPreviewRec(v.key).accepted
+	if id == "" {
+		return nil
+	}
+
+	var (
+		cl  *cutlist
+		err error
+	)
+	// local cutlists are identified by their path
+	if filepath.IsAbs(id) {
+		cl, err = readCutlistFile(id)
+	} else {
+		w := *v
+		w.clID = id
+		cl, err = w.loadChosenCutlist()
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"key": v.key}).Warnf("Cutlist %s that has been accepted in preview cannot be loaded: %v", id, err)
+		return nil
+	}
+	cl.reason = fmt.Sprintf("Cutlist %s: accepted in preview", id)
+	return cl
+}
+
+// previewClip is a part of the decoded video that is played in the preview
+type previewClip struct {
+	start, end float64 // start and end (in seconds)
+	label      string  // description of the clip
+}
+
+// previewClips returns the clips of the video that are played in the preview:
+// window seconds before and after the start and the end of each segment of
+// the cutlist. dur is the duration of the video (0 if unknown). Overlapping
+// clips are merged
+func (cl *cutlist) previewClips(window, dur float64) []previewClip {
+	var cs []previewClip
+
+	add := func(t float64, label string) {
+		c := previewClip{start: t - window, end: t + window, label: label}
+		if c.start < 0 {
+			c.start = 0
+		}
+		if dur > 0 && c.end > dur {
+			c.end = dur
+		}
+		if c.end <= c.start {
+			return
+		}
+		if n := len(cs); n > 0 && c.start <= cs[n-1].end {
+			cs[n-1].end = c.end
+			cs[n-1].label += ", " + label
+			return
+		}
+		cs = append(cs, c)
+	}
+	for i := range cl.segs {
+		start, end := cl.times(i)
+		add(start, fmt.Sprintf("start of segment %d", i+1))
+		add(end, fmt.Sprintf("end of segment %d", i+1))
+	}
+	return cs
+}
+
+// previewEDL returns the content of the mpv EDL file that plays the clips of
+// the decoded video filePath
+func previewEDL(filePath string, cs []previewClip) string {
+	s := markMPVHeader + "\n"
+	for _, c := range cs {
+		s += fmt.Sprintf("%%%d%%%s,%s,%s\n", len(filePath), filePath, fmtSec(c.start), fmtSec(c.end-c.start))
+	}
+	return s
+}
+
+// preview plays the boundaries of the segments of the cutlist cl with mpv.
+// window is the number of seconds that are played before and after each
+// boundary
+func (v *video) preview(cl *cutlist, window float64) error {
+	w := v.decVideo()
+	if !exists(w.filePath) {
+		return fmt.Errorf("Decoded video %s doesn't exist", w.filePath)
+	}
+	var dur float64
+	if w.mi != nil {
+		dur = w.mi.dur
+	}
+	filePath, _ := filepath.Abs(w.filePath)
+
+	cs := cl.previewClips(window, dur)
+	if len(cs) == 0 {
+		return fmt.Errorf("Cutlist %s doesn't contain any segment", cl.id)
+	}
+
+	// write EDL file into a temporary directory
+	tmpDir, err := ioutil.TempDir("", "gool-preview-")
+	if err != nil {
+		return fmt.Errorf("Temporary directory cannot be created: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+	edlPath := filepath.Join(tmpDir, v.key+markSuffixMPV)
+	if err = ioutil.WriteFile(edlPath, []byte(previewEDL(filePath, cs)), 0644); err != nil {
+		return fmt.Errorf("EDL file cannot be written: %v", err)
+	}
+
+	// print clips
+	fmt.Printf("\n\033[1m%s\033[22m: cutlist %s, %d cuts, duration %s\n", v.key, cl.id, len(cl.segs), timeStr(cl.cutDur())[:8])
+	for i, c := range cs {
+		fmt.Printf("%3d) %s - %s: %s\n", i+1, timeStr(c.start)[:8], timeStr(c.end)[:8], c.label)
+	}
+
+	// play clips
+	cmd := exec.Command(mpvName, edlPath)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	log.WithFields(log.Fields{"key": v.key}).Infof("Execute %v", cmd.Args)
+	if err = cmd.Run(); err != nil {
+		return fmt.Errorf("Preview cannot be played with %s: %v", mpvName, err)
+	}
+	return nil
+}
+
+// previewID returns the ID under which preview decisions for the cutlist are
+// stored: Local cutlists are identified by their path (even if the file
+// contains a cutlist ID), since they are loaded and rejected by path. Other
+// cutlists are identified by their ID
+func (cl *cutlist) previewID() string {
+	if cl.src != "" {
+		filePath, _ := filepath.Abs(cl.src)
+		return filePath
+	}
+	return cl.id
+}
+
+// askPreviewDecision asks the user whether the cutlist cl of the video shall
+// be accepted or rejected and stores the decision in the preview record
+func (v *video) askPreviewDecision(cl *cutlist) error {
+	rec := readPreviewRec(v.key)
+	id := cl.previewID()
+
+	in := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Printf("\nCutlist %s: [a]ccept, [r]eject, Enter: no decision: ", cl.id)
+		input, _ := in.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(input)) {
+		case "":
+			return nil
+		case "a":
+			rec.accept(id)
+			log.WithFields(log.Fields{"key": v.key}).Infof("Cutlist %s has been accepted in preview", cl.id)
+		case "r":
+			rec.reject(id)
+			log.WithFields(log.Fields{"key": v.key}).Infof("Cutlist %s has been rejected in preview", cl.id)
+		default:
+			fmt.Println("Invalid input")
+			continue
+		}
+		break
+	}
+
+	if err := rec.write(v.key); err != nil {
+		return fmt.Errorf("Preview record of %s cannot be written: %v", v.key, err)
+	}
+	return nil
+}
+
+// previewCmd implements the sub command "preview": It determines the cutlist
+// of the video vid (or the cutlist with the ID clID), plays the boundaries of
+// its segments and asks the user for a decision
+func previewCmd(vid, clID string, window float64) error {
+	v, cl, err := cutlistOfVideo(vid, clID, "")
+	if err != nil {
+		return err
+	}
+	if err = v.preview(cl, window); err != nil {
+		return err
+	}
+	return v.askPreviewDecision(cl)
+}
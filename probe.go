@@ -0,0 +1,342 @@
+// Copyright (C) 2018 Michael Picht
+//
+// This file is part of gool (Online TV Recorder on Linux in Go).
+//
+// gool is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// gool is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with gool. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+// probe.go implements the determination of media information (duration,
+// frame rate, resolution, tracks) of decoded videos with FFprobe (or with
+// MKVmerge, if FFprobe is not installed), and the validation of cutlists
+// against this information: Cutlists with a frame rate that doesn't fit to
+// the video and cutlists with segments that overlap or exceed the duration of
+// the video are repaired if possible. Otherwise, they are rejected.
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Tolerance for the comparison of frame rates
+const fpsTolerance = 0.01
+
+// mediaInfo contains the media information of a decoded video
+type mediaInfo struct {
+	dur     float64  // duration (in seconds)
+	fps     float64  // frame rate (frames per second)
+	width   int      // width of video (in pixels)
+	height  int      // height of video (in pixels)
+	vCodec  string   // video codec
+	profile string   // profile of the video codec (e.g. "High")
+	level   int      // level of the video codec (e.g. 40 for H.264 level 4.0)
+	pixFmt  string   // pixel format (e.g. "yuv420p")
+	tb      string   // time base of the video stream (e.g. "1/90000")
+	aCodecs []string // codecs of the audio tracks
+}
+
+// ffprobeOutput is the part of the JSON output of FFprobe that is relevant
+// for gool
+type ffprobeOutput struct {
+	Format struct {
+		Duration string `json:"duration"`
+	} `json:"format"`
+	Streams []struct {
+		CodecType    string `json:"codec_type"`
+		CodecName    string `json:"codec_name"`
+		Profile      string `json:"profile"`
+		Level        int    `json:"level"`
+		PixFmt       string `json:"pix_fmt"`
+		TimeBase     string `json:"time_base"`
+		Width        int    `json:"width"`
+		Height       int    `json:"height"`
+		AvgFrameRate string `json:"avg_frame_rate"`
+		RFrameRate   string `json:"r_frame_rate"`
+	} `json:"streams"`
+}
+
+// parseRate converts a frame rate in the format "num/den" (e.g. "25/1") into
+// a float. If that's not possible, 0 is returned
+func parseRate(s string) float64 {
+	nd := strings.SplitN(s, "/", 2)
+	num, err := strconv.ParseFloat(nd[0], 64)
+	if err != nil {
+		return 0
+	}
+	if len(nd) == 1 {
+		return num
+	}
+	den, err := strconv.ParseFloat(nd[1], 64)
+	if err != nil || den == 0 {
+		return 0
+	}
+	return num / den
+}
+
+// mkvmergeIdent is the part of the JSON output of "mkvmerge -J" that is
+// relevant for gool. It's used if FFprobe is not installed
+type mkvmergeIdent struct {
+	Container struct {
+		Properties struct {
+			Duration int64 `json:"duration"` // in nanoseconds
+		} `json:"properties"`
+	} `json:"container"`
+	Tracks []struct {
+		ID         int    `json:"id"`
+		Type       string `json:"type"`
+		Codec      string `json:"codec"`
+		Properties struct {
+			PixelDimensions string `json:"pixel_dimensions"`
+			DefaultDuration int64  `json:"default_duration"` // duration of a frame in nanoseconds
+		} `json:"properties"`
+	} `json:"tracks"`
+}
+
+// canProbe checks if a tool to determine media information (FFprobe or
+// MKVmerge) is installed
+func canProbe() bool {
+	for _, name := range []string{ffprobeName, mkvmergeName} {
+		if _, err := exec.LookPath(name); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// probe determines the media information of the video by calling FFprobe and
+// stores it in the video. If FFprobe is not installed, MKVmerge is used
+// instead. Encoded videos cannot be probed
+func (v *video) probe() error {
+	if v.status == vidStatusEnc {
+		return fmt.Errorf("Encoded video %s cannot be probed", v.filePath)
+	}
+	if _, err := exec.LookPath(ffprobeName); err != nil {
+		return v.probeMKVmerge()
+	}
+
+	cmd := exec.Command(ffprobeName,
+		"-v", "error",
+		"-show_entries", "format=duration:stream=codec_type,codec_name,profile,level,pix_fmt,time_base,width,height,avg_frame_rate,r_frame_rate",
+		"-of", "json",
+		v.filePath)
+	log.WithFields(log.Fields{"key": v.key}).Debugf("Probe command: %s", strings.Join(cmd.Args, " "))
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("Media information of %s cannot be determined: %v", v.filePath, err)
+	}
+
+	var fo ffprobeOutput
+	if err = json.Unmarshal(out, &fo); err != nil {
+		return fmt.Errorf("Media information of %s cannot be interpreted: %v", v.filePath, err)
+	}
+
+	mi := new(mediaInfo)
+	mi.dur, _ = strconv.ParseFloat(fo.Format.Duration, 64)
+	for _, st := range fo.Streams {
+		switch st.CodecType {
+		case "video":
+			// only the first video stream is relevant
+			if mi.vCodec != "" {
+				continue
+			}
+			mi.vCodec = st.CodecName
+			mi.profile, mi.level, mi.pixFmt, mi.tb = st.Profile, st.Level, st.PixFmt, st.TimeBase
+			mi.width, mi.height = st.Width, st.Height
+			if mi.fps = parseRate(st.AvgFrameRate); mi.fps == 0 {
+				mi.fps = parseRate(st.RFrameRate)
+			}
+		case "audio":
+			mi.aCodecs = append(mi.aCodecs, st.CodecName)
+		}
+	}
+	v.mi = mi
+
+	return nil
+}
+
+// probeMKVmerge determines the media information of the video by calling
+// "mkvmerge -J" and stores it in the video. MKVmerge doesn't provide profile,
+// level, pixel format and time base of the video stream. The codec names are
+// converted into the names FFprobe uses
+func (v *video) probeMKVmerge() error {
+	cmd := exec.Command(mkvmergeName, "-J", v.filePath)
+	log.WithFields(log.Fields{"key": v.key}).Debugf("Probe command: %s", strings.Join(cmd.Args, " "))
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("Media information of %s cannot be determined: %v", v.filePath, err)
+	}
+
+	var id mkvmergeIdent
+	if err = json.Unmarshal(out, &id); err != nil {
+		return fmt.Errorf("Media information of %s cannot be interpreted: %v", v.filePath, err)
+	}
+
+	mi := new(mediaInfo)
+	mi.dur = float64(id.Container.Properties.Duration) / 1e9
+	for _, tr := range id.Tracks {
+		switch tr.Type {
+		case "video":
+			// only the first video track is relevant
+			if mi.vCodec != "" {
+				continue
+			}
+			switch codec := strings.ToUpper(tr.Codec); {
+			case strings.Contains(codec, "AVC"):
+				mi.vCodec = "h264"
+			case strings.Contains(codec, "HEVC"):
+				mi.vCodec = "hevc"
+			case strings.Contains(codec, "MPEG-4P2"):
+				mi.vCodec = "mpeg4"
+			default:
+				mi.vCodec = strings.ToLower(tr.Codec)
+			}
+			_, _ = fmt.Sscanf(tr.Properties.PixelDimensions, "%dx%d", &mi.width, &mi.height)
+			if tr.Properties.DefaultDuration > 0 {
+				mi.fps = 1e9 / float64(tr.Properties.DefaultDuration)
+			}
+		case "audio":
+			mi.aCodecs = append(mi.aCodecs, strings.ToLower(strings.Replace(tr.Codec, "-", "", -1)))
+		}
+	}
+	v.mi = mi
+
+	return nil
+}
+
+// string returns the media information as string
+func (mi *mediaInfo) string() string {
+	return fmt.Sprintf("%dx%d, %.2f fps, %s, %s, audio: %s",
+		mi.width, mi.height, mi.fps, timeStr(mi.dur)[:8], mi.vCodec, strings.Join(mi.aCodecs, ", "))
+}
+
+// probe determines the media information of all videos that are not encoded.
+// Errors are logged only
+func (vl videoList) probe() {
+	for _, v := range vl {
+		if v.status == vidStatusEnc {
+			continue
+		}
+		if err := v.probe(); err != nil {
+			log.WithFields(log.Fields{"key": v.key}).Warn(err.Error())
+		}
+	}
+}
+
+// validateCutlist checks the cutlist of the video against the media
+// information of the video. If the frame rate of the cutlist doesn't fit to
+// the video, the times are used instead of the frame numbers. Overlapping
+// segments are merged, and segments that exceed the duration of the video are
+// truncated. If the cutlist doesn't contain any segment within the duration
+// of the video, an error is returned.
+func (v *video) validateCutlist() error {
+	cl := v.cl
+
+	// without FFprobe and MKVmerge, the cutlist cannot be validated
+	if v.mi == nil && !canProbe() {
+		log.WithFields(log.Fields{"key": v.key}).Warnf("Cutlist ID=%s cannot be validated: Neither %s nor %s is installed", cl.id, ffprobeName, mkvmergeName)
+		return nil
+	}
+	if v.mi == nil {
+		if err := v.probe(); err != nil {
+			return err
+		}
+	}
+	mi := v.mi
+
+	var repairs []string
+
+	// check frame rate
+	if cl.frameBased && cl.fps > 0 && mi.fps > 0 && math.Abs(cl.fps-mi.fps) > fpsTolerance {
+		repairs = append(repairs, fmt.Sprintf("frame rate %.3f doesn't fit to %.3f", cl.fps, mi.fps))
+		// convert frames into times (based on the frame rate of the cutlist)
+		// if the cutlist doesn't contain times
+		if !cl.timeBased {
+			cl.toTimes()
+		}
+		cl.frameBased = false
+	}
+
+	// get segments as time intervals, sorted by start
+	type interval struct{ start, end float64 }
+	var ivs []interval
+	for i := range cl.segs {
+		start, end := cl.times(i)
+		ivs = append(ivs, interval{start, end})
+	}
+	sort.Slice(ivs, func(i, j int) bool { return ivs[i].start < ivs[j].start })
+
+	// merge overlapping segments and truncate segments at the end of the video
+	var res []interval
+	for _, iv := range ivs {
+		if mi.dur > 0 && iv.end > mi.dur {
+			if iv.start >= mi.dur {
+				repairs = append(repairs, fmt.Sprintf("segment at %s starts after the end of the video", timeStr(iv.start)))
+				continue
+			}
+			repairs = append(repairs, fmt.Sprintf("segment at %s exceeds the end of the video", timeStr(iv.start)))
+			iv.end = mi.dur
+		}
+		if n := len(res); n > 0 && iv.start < res[n-1].end {
+			repairs = append(repairs, fmt.Sprintf("segment at %s overlaps with its predecessor", timeStr(iv.start)))
+			if iv.end > res[n-1].end {
+				res[n-1].end = iv.end
+			}
+			continue
+		}
+		res = append(res, iv)
+	}
+	if len(res) == 0 {
+		return fmt.Errorf("Cutlist ID=%s doesn't contain segments within the duration of the video (%s)", cl.id, timeStr(mi.dur))
+	}
+	if len(repairs) == 0 {
+		return nil
+	}
+
+	// store the repaired segments as time based segments
+	cl.segs = nil
+	for _, iv := range res {
+		sg := &seg{timeStart: iv.start, timeDur: iv.end - iv.start}
+		if mi.fps > 0 {
+			sg.frameStart = int(math.Floor(iv.start*mi.fps + 0.5))
+			sg.frameDur = int(math.Floor((iv.end-iv.start)*mi.fps + 0.5))
+		}
+		cl.segs = append(cl.segs, sg)
+	}
+	cl.timeBased = true
+	cl.frameBased = false
+
+	for _, r := range repairs {
+		log.WithFields(log.Fields{"key": v.key}).Warnf("Cutlist ID=%s has been repaired: %s", cl.id, r)
+	}
+	cl.reason += fmt.Sprintf(", repaired (%s)", strings.Join(repairs, "; "))
+
+	return nil
+}
+
+// toTimes calculates the times of the segments from the frame numbers and
+// the frame rate of the cutlist
+func (cl *cutlist) toTimes() {
+	for i, sg := range cl.segs {
+		start, end := cl.times(i)
+		sg.timeStart, sg.timeDur = start, end-start
+	}
+	cl.timeBased = true
+}
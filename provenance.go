@@ -0,0 +1,286 @@
+// Copyright (C) 2018 Michael Picht
+//
+// This file is part of gool (Online TV Recorder on Linux in Go).
+//
+// gool is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// gool is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with gool. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+// provenance.go implements the provenance sidecar: For each cut video, a JSON
+// file with the same name (but suffix ".json") is written next to it. It
+// records where the video comes from (OTR files and their sizes), the cutlist
+// that has been used (incl. its segments), the cutlists that have been
+// rejected, the versions of the tools, the processing times and the checksum
+// of the cut video. This allows to audit bad cuts and to reproduce them.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// suffix of provenance sidecar files
+const provSuffix = ".json"
+
+// procStats contains information about the processing of a video that is
+// needed for the provenance sidecar
+type procStats struct {
+	encSize int64         // size of the otrkey file (0 if unknown)
+	decName string        // name of the decoded file
+	decSize int64         // size of the decoded file
+	decDur  time.Duration // duration of decoding
+	cutDur  time.Duration // duration of cutting
+	cutter  string        // name of the cutter
+	cutProg string        // program that has been used for cutting
+}
+
+// programs that are called by the cutters
+var cutterProgs = map[string]string{
+	cutterMKVmerge: mkvmergeName,
+	cutterFFmpeg:   ffmpegName,
+	cutterAvidemux: avidemuxName,
+}
+
+// arguments to get the version of the tools
+var toolVersionArgs = map[string]string{
+	otrDecoderName: "-v",
+	mkvmergeName:   "--version",
+	ffmpegName:     "-version",
+	avidemuxName:   "--version",
+}
+
+// tool versions are determined only once per run
+var (
+	toolVersions   = make(map[string]string)
+	toolVersionsMu sync.Mutex
+)
+
+// JSON structures of the provenance sidecar
+type provenance struct {
+	Key      string            `json:"key"`
+	Created  time.Time         `json:"created"`
+	Source   provSource        `json:"source"`
+	Cutlist  *provCutlist      `json:"cutlist,omitempty"`
+	Rejected []provCandidate   `json:"rejected_cutlists,omitempty"`
+	Cutter   string            `json:"cutter"`
+	Tools    map[string]string `json:"tools"`
+	Timings  provTimings       `json:"timings"`
+	Outputs  []provOutput      `json:"outputs"`
+}
+type provSource struct {
+	OTRKeyFile  string `json:"otrkey_file"`
+	OTRKeySize  int64  `json:"otrkey_size,omitempty"`
+	DecodedFile string `json:"decoded_file"`
+	DecodedSize int64  `json:"decoded_size,omitempty"`
+}
+type provCutlist struct {
+	ID          string    `json:"id"`
+	File        string    `json:"file,omitempty"`
+	Author      string    `json:"author,omitempty"`
+	Rating      float64   `json:"rating,omitempty"`
+	RatingCount int       `json:"rating_count,omitempty"`
+	FPS         float64   `json:"fps,omitempty"`
+	Match       string    `json:"match,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+	Segments    []provSeg `json:"segments"`
+}
+type provSeg struct {
+	Start      float64 `json:"start"`
+	Duration   float64 `json:"duration"`
+	StartFrame int     `json:"start_frame,omitempty"`
+	Frames     int     `json:"frames,omitempty"`
+}
+type provCandidate struct {
+	ID     string  `json:"id"`
+	Author string  `json:"author,omitempty"`
+	Rating float64 `json:"rating,omitempty"`
+	Reason string  `json:"reason"`
+}
+type provTimings struct {
+	DecodeSeconds float64 `json:"decode_seconds,omitempty"`
+	CutSeconds    float64 `json:"cut_seconds"`
+}
+type provOutput struct {
+	File   string `json:"file"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// provPath returns the path of the provenance sidecar of the video filePath
+func provPath(filePath string) string {
+	return strings.TrimSuffix(filePath, filepath.Ext(filePath)) + provSuffix
+}
+
+// toolVersion returns the version of the program prog (the first line of its
+// version output). If it cannot be determined, an empty string is returned
+func toolVersion(prog string) string {
+	toolVersionsMu.Lock()
+	defer toolVersionsMu.Unlock()
+
+	if ver, ok := toolVersions[prog]; ok {
+		return ver
+	}
+
+	progPath := prog
+	if prog == otrDecoderName && cfg.otrDecDirPath != "" {
+		progPath = cfg.otrDecDirPath + "/" + otrDecoderName
+	}
+	// the exit code is ignored since some tools don't have a dedicated version
+	// option but print their version together with the usage
+	out, _ := exec.Command(progPath, toolVersionArgs[prog]).CombinedOutput()
+	var ver string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			ver = line
+			break
+		}
+	}
+	toolVersions[prog] = ver
+	return ver
+}
+
+// sha256File returns the SHA-256 checksum of the file filePath (hex encoded)
+func sha256File(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// provenance assembles the provenance information of the cut video
+func (v *video) provenance() (*provenance, error) {
+	p := &provenance{
+		Key:     v.key,
+		Created: time.Now(),
+		Source: provSource{
+			OTRKeyFile:  v.ri.fileName(v.ri.quality) + otrSuffixKey,
+			OTRKeySize:  v.stats.encSize,
+			DecodedFile: v.stats.decName,
+			DecodedSize: v.stats.decSize,
+		},
+		Cutter: v.stats.cutter,
+		Tools:  make(map[string]string),
+		Timings: provTimings{
+			DecodeSeconds: v.stats.decDur.Seconds(),
+			CutSeconds:    v.stats.cutDur.Seconds(),
+		},
+	}
+
+	// tool versions (otrdecoder only if the video has been decoded in this
+	// run)
+	if v.stats.decDur > 0 {
+		p.Tools[otrDecoderName] = toolVersion(otrDecoderName)
+	}
+	if v.stats.cutProg != "" {
+		p.Tools[v.stats.cutProg] = toolVersion(v.stats.cutProg)
+	}
+
+	// cutlist that has been used
+	if v.cl != nil {
+		p.Cutlist = v.provCutlist(v.cl)
+	}
+
+	// cutlists that have been rejected
+	for _, clh := range v.clhs {
+		if clh.rejected {
+			p.Rejected = append(p.Rejected, provCandidate{ID: clh.id, Author: clh.author, Rating: clh.rating, Reason: clh.reason})
+		}
+	}
+
+	// cut video
+	info, err := os.Stat(v.cutFilePath)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := sha256File(v.cutFilePath)
+	if err != nil {
+		return nil, err
+	}
+	p.Outputs = append(p.Outputs, provOutput{File: filepath.Base(v.cutFilePath), Size: info.Size(), SHA256: sum})
+
+	return p, nil
+}
+
+// provCutlist returns the information about the cutlist cl of the video. The
+// rating is taken from the cutlist headers (if available)
+func (v *video) provCutlist(cl *cutlist) *provCutlist {
+	pcl := &provCutlist{
+		ID:     cl.id,
+		File:   cl.src,
+		Author: cl.author,
+		FPS:    cl.fps,
+		Match:  cl.match,
+		Reason: cl.reason,
+	}
+	for i, sg := range cl.segs {
+		start, end := cl.times(i)
+		pcl.Segments = append(pcl.Segments, provSeg{
+			Start:      start,
+			Duration:   end - start,
+			StartFrame: sg.frameStart,
+			Frames:     sg.frameDur,
+		})
+	}
+	for _, clh := range v.clhs {
+		if clh.id == cl.id {
+			pcl.Rating = clh.rating
+			pcl.RatingCount = clh.ratingCount
+		}
+	}
+	return pcl
+}
+
+// writeProvenance writes the provenance sidecar of the cut video
+func (v *video) writeProvenance() {
+	p, err := v.provenance()
+	if err != nil {
+		log.WithFields(log.Fields{"key": v.key}).Errorf("Provenance of %s cannot be determined: %v", v.cutFilePath, err)
+		return
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		log.WithFields(log.Fields{"key": v.key}).Errorf("Provenance of %s cannot be encoded: %v", v.cutFilePath, err)
+		return
+	}
+	if err = writeFileAtomic(provPath(v.cutFilePath), append(data, '\n')); err != nil {
+		log.WithFields(log.Fields{"key": v.key}).Errorf("Provenance sidecar of %s cannot be written: %v", v.cutFilePath, err)
+	}
+}
+
+// moveProvenance moves the provenance sidecar of the video from to the
+// corresponding path of the video to (if the sidecar exists)
+func moveProvenance(from, to string) {
+	if !exists(provPath(from)) {
+		return
+	}
+	if err := os.Rename(provPath(from), provPath(to)); err != nil {
+		log.Errorf("%s cannot be moved to %s: %v", provPath(from), provPath(to), err)
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright (C) 2018 Michael Picht
+//
+// This file is part of gool (Online TV Recorder on Linux in Go).
+//
+// gool is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// gool is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with gool. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+// rename.go implements the renaming of videos that have already been cut
+// (sub command "rename"). The configured naming template is applied to the
+// videos in the "Cut" directory. The metadata is taken from the OTR file
+// name and - if available - from the cut record and the cached cutlist.
+// Each rename run is written into a journal. This allows to undo the last
+// run.
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// name of the rename journal (in the log directory)
+const renameJournalName = "rename.journal"
+
+// suffices of the files in the cut directory that are renamed
+var renameSuffices = [...]string{".mkv", ".avi", ".mp4"}
+
+// renaming of one file
+type renaming struct {
+	from string // old path
+	to   string // new path
+	rec  *cutRec
+}
+
+// renameJournalPath returns the path of the rename journal
+func renameJournalPath() string {
+	return cfg.logDirPath + "/" + renameJournalName
+}
+
+// metadataOfCutFile determines the metadata of the cut video filePath: The
+// recording info, the key and the cutlist (can be nil). If the metadata
+// cannot be determined, an error is returned
+func metadataOfCutFile(filePath string, recs map[string]*cutRec) (*recInfo, string, *cutlist, error) {
+	// cut record exists: take metadata from the source file and the cutlist
+	if rec := recs[filePath]; rec != nil {
+		ri, _, _, err := parseFileName(rec.source)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		return ri, ri.key(ri.quality), rec.cutlist(), nil
+	}
+
+	// otherwise: the file must have an OTR file name
+	key, ri, _, _, err := analyzeFile(filepath.Base(filePath))
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("Metadata of %s cannot be determined: No OTR file name and no cut record", filePath)
+	}
+	return ri, key, nil, nil
+}
+
+// planRenamings determines the new names of the videos in the cut directory
+func planRenamings() ([]renaming, error) {
+	var rns []renaming
+
+	recs := readCutRecs()
+
+	filePaths, err := filepath.Glob(cfg.cutDirPath + "/*")
+	if err != nil {
+		return nil, err
+	}
+
+	// new paths that are already planned (to avoid collisions between the
+	// renamed files)
+	planned := make(map[string]bool)
+
+	// renamings must never overwrite other files, since that couldn't be
+	// undone. Thus, the collision strategy "overwrite" is not applied here
+	strategy := cfg.nameColl
+	if strategy == nameCollOverwrite {
+		log.Warnf("Collision strategy '%s' is not supported for renaming: Use '%s' instead", nameCollOverwrite, nameCollSuffix)
+		strategy = nameCollSuffix
+	}
+
+	for _, filePath := range filePaths {
+		suffix := filepath.Ext(filePath)
+		relevant := false
+		for _, s := range renameSuffices {
+			relevant = relevant || strings.EqualFold(s, suffix)
+		}
+		if !relevant {
+			continue
+		}
+
+		ri, key, cl, err := metadataOfCutFile(filePath, recs)
+		if err != nil {
+			log.Warn(err.Error())
+			fmt.Printf("Skip %s: %v\n", filepath.Base(filePath), err)
+			continue
+		}
+
+		name := expandNameTemplate(cfg.nameTmpl, nameFields(ri, cl, key))
+		if name == "" {
+			name = key + otrInfixCut
+		}
+		if filepath.Join(cfg.cutDirPath, name+suffix) == filePath {
+			continue
+		}
+		// a path is taken if another file exists there or if it's the new
+		// path of another file
+		to, err := resolveCollision(cfg.cutDirPath, name, suffix, strategy, func(p string) bool {
+			return planned[p] || (p != filePath && exists(p))
+		})
+		if err != nil {
+			fmt.Printf("Skip %s: %v\n", filepath.Base(filePath), err)
+			continue
+		}
+		// the file already has the right name (incl. collision suffix)
+		if to == filePath {
+			continue
+		}
+		planned[to] = true
+
+		rn := renaming{from: filePath, to: to, rec: recs[filePath]}
+		if rn.rec == nil {
+			rn.rec = &cutRec{key: key, source: ri.fileName(ri.quality), recPath: cutRecPath(key)}
+		}
+		rns = append(rns, rn)
+	}
+
+	return rns, nil
+}
+
+// rename applies the naming template to the videos in the cut directory. If
+// dryRun is true, the renamings are only displayed
+func rename(dryRun bool) error {
+	rns, err := planRenamings()
+	if err != nil {
+		return err
+	}
+	if len(rns) == 0 {
+		fmt.Println("\nNothing to rename")
+		return nil
+	}
+
+	// open journal
+	var journal *os.File
+	if !dryRun {
+		if journal, err = os.OpenFile(renameJournalPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err != nil {
+			return fmt.Errorf("Rename journal cannot be opened: %v", err)
+		}
+		defer func() { _ = journal.Close() }()
+	}
+	run := time.Now().Format(time.RFC3339)
+
+	fmt.Println()
+	for _, rn := range rns {
+		fmt.Printf("%s\n  -> %s\n", filepath.Base(rn.from), filepath.Base(rn.to))
+		if dryRun {
+			continue
+		}
+		if err = os.Rename(rn.from, rn.to); err != nil {
+			log.Errorf("%s cannot be renamed: %v", rn.from, err)
+			fmt.Printf("  %s cannot be renamed: %v\n", rn.from, err)
+			continue
+		}
+		log.Infof("%s has been renamed to %s", rn.from, rn.to)
+		moveProvenance(rn.from, rn.to)
+		if _, err = fmt.Fprintf(journal, "%s\t%s\t%s\n", run, rn.from, rn.to); err != nil {
+			log.Errorf("Rename journal cannot be written: %v", err)
+		}
+		// update cut record
+		rn.rec.filePath = rn.to
+		if err = rn.rec.write(); err != nil {
+			log.Errorf("Cut record cannot be written: %v", err)
+		}
+	}
+	if dryRun {
+		fmt.Println("\nDry run: Nothing has been renamed")
+	}
+
+	return nil
+}
+
+// undoRename reverts the last rename run that is recorded in the journal. The
+// entries of that run are removed from the journal
+func undoRename() error {
+	var (
+		lines []string
+		last  string
+	)
+
+	// read journal
+	f, err := os.Open(renameJournalPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Println("\nNothing to undo")
+			return nil
+		}
+		return fmt.Errorf("Rename journal cannot be read: %v", err)
+	}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if line := sc.Text(); line != "" {
+			lines = append(lines, line)
+			last = strings.SplitN(line, "\t", 2)[0]
+		}
+	}
+	_ = f.Close()
+	if len(lines) == 0 {
+		fmt.Println("\nNothing to undo")
+		return nil
+	}
+
+	recs := readCutRecs()
+
+	// revert renamings of last run (in reverse order)
+	var keep []string
+	fmt.Println()
+	for i := len(lines) - 1; i >= 0; i-- {
+		fields := strings.Split(lines[i], "\t")
+		if len(fields) != 3 || fields[0] != last {
+			keep = append([]string{lines[i]}, keep...)
+			continue
+		}
+		from, to := fields[1], fields[2]
+		fmt.Printf("%s\n  -> %s\n", filepath.Base(to), filepath.Base(from))
+		if exists(from) {
+			fmt.Printf("  %s already exists: Not reverted\n", from)
+			keep = append([]string{lines[i]}, keep...)
+			continue
+		}
+		if err = os.Rename(to, from); err != nil {
+			log.Errorf("%s cannot be renamed: %v", to, err)
+			fmt.Printf("  %s cannot be renamed: %v\n", to, err)
+			keep = append([]string{lines[i]}, keep...)
+			continue
+		}
+		log.Infof("%s has been renamed back to %s", to, from)
+		moveProvenance(to, from)
+		// update cut record
+		if rec := recs[to]; rec != nil {
+			rec.filePath = from
+			if err = rec.write(); err != nil {
+				log.Errorf("Cut record cannot be written: %v", err)
+			}
+		}
+	}
+
+	// write remaining entries back to journal
+	data := strings.Join(keep, "\n")
+	if data != "" {
+		data += "\n"
+	}
+	if err = writeFileAtomic(renameJournalPath(), []byte(data)); err != nil {
+		return fmt.Errorf("Rename journal cannot be written: %v", err)
+	}
+
+	return nil
+}
@@ -25,6 +25,7 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -38,6 +39,7 @@ const (
 	vidStatusEnc = "ENC" // encoded
 	vidStatusDec = "DEC" // decoded
 	vidStatusCut = "CUT" // cut
+	vidStatusMrk = "MRK" // marked (decoded, with skip files instead of being cut)
 )
 
 // Constants for video processing status
@@ -49,7 +51,8 @@ const (
 
 // Constants for printing video information
 const (
-	vidPrtKeyLen    = 59 // key length
+	vidPrtKeyLen    = 54 // key length
+	vidPrtQualLen   = 4  // quality length
 	vidPrtCLLen     = 2  // length of cutlist existence indicator
 	vidPrtStatusLen = 7  // Status length
 	vidPrtResLen    = 8  // result length
@@ -63,29 +66,47 @@ type res struct {
 
 // Represents one video
 type video struct {
-	key      string // key [= file name without (a) suffix ".otrkey", (b) sub string "cut." and (c) file type (.avi, .mkv etc.)]
-	cf       string // container format of the video (e.g. "avi", "mkv")
-	status   string // Whether a video is encoded, decoded or cut
-	res      string
+	key         string   // key [= file name without (a) suffix ".otrkey", (b) sub string "cut." and (c) file type (.avi, .mkv etc.)]
+	ri          *recInfo // metadata of the recording (parsed from the file name)
+	cf          string   // container format of the video (e.g. "avi", "mkv")
+	status      string   // Whether a video is encoded, decoded or cut
+	res         string
+	filePath    string
+	ac3         *audio           // separate AC3 audio file (only for HD recordings)
+	clFiles     []string         // local cutlist files
+	clhs        clHeaders        // headers of the cutlists from the cutlist server
+	clID        string           // ID of the cutlist that has been chosen by the user
+	mi          *mediaInfo       // media information of the decoded video
+	size        int64            // size of the decoded video file (0 if not known)
+	cutFilePath string           // path of the cut video (once it has been cut)
+	stats       procStats        // processing information for the provenance sidecar
+	cl          *cutlist         // cutlists
+	pbs         map[int]*mpb.Bar // progress bars (key is action, like "decode", "cut", "load cutlist")
+}
+
+// Represents the separate AC3 audio file that OTR ships for HD recordings. It
+// is decoded together with the video and muxed into the cut video as additional
+// audio track
+type audio struct {
+	status   string // Whether the audio file is encoded or decoded
 	filePath string
-	cl       *cutlist         // cutlists
-	pbs      map[int]*mpb.Bar // progress bars (key is action, like "decode", "cut", "load cutlist")
 }
 
 // format str for listing videos
-var vidFormatStr = "%-" + strconv.Itoa(vidPrtKeyLen) + "s %-" + strconv.Itoa(vidPrtStatusLen) + "s %-" + strconv.Itoa(vidPrtCLLen) + "s %-" + strconv.Itoa(vidPrtResLen) + "s"
+var vidFormatStr = "%-" + strconv.Itoa(vidPrtKeyLen) + "s %-" + strconv.Itoa(vidPrtQualLen) + "s %-" + strconv.Itoa(vidPrtStatusLen) + "s %-" + strconv.Itoa(vidPrtCLLen) + "s %-" + strconv.Itoa(vidPrtResLen) + "s"
 
 // constants to indicate actions
 const (
-	prgActDec = iota // action "decode"
-	prgActCL         // action "load cutlist"
-	prgActCut        // action "cut"
+	prgActDec    = iota // action "decode"
+	prgActCL            // action "load cutlist"
+	prgActCut           // action "cut"
+	prgActDecAC3        // action "decode AC3 audio"
 )
 
 // constants for string lengths
 const (
 	prgBarLen = 20 // length of progress bar
-	prgKeyLen = 38 // length of video key in front of progress bar
+	prgKeyLen = 28 // length of video key in front of progress bar
 )
 
 // progress container
@@ -132,14 +153,22 @@ func (v *video) getBar(act int) *mpb.Bar {
 	// read bar from map. If there's no bar for the given video / action
 	// combination ...
 	if bar, ok = v.pbs[act]; !ok {
+		// decorators right of the bar: percentage and - for actions that
+		// process a file - ETA and throughput
+		appDecs := []decor.DecoratorFunc{decor.Percentage(3, decor.DSyncSpace)}
+		if size := v.prgSize(act); size > 0 {
+			appDecs = append(appDecs,
+				decor.ETA(3, decor.DSyncSpace),
+				decor.DynamicName(throughput(size), 3, decor.DSyncSpace),
+			)
+		}
+
 		// create new bar
 		bar = p.AddBar(100,
 			mpb.PrependDecorators(
 				decor.StaticName(v.prependStr(act), 0, 0),
 			),
-			mpb.AppendDecorators(
-				decor.Percentage(3, decor.DSyncSpace),
-			),
+			mpb.AppendDecorators(appDecs...),
 			mpb.BarTrim(),
 		)
 
@@ -150,6 +179,73 @@ func (v *video) getBar(act int) *mpb.Bar {
 	return bar
 }
 
+// prgSize returns the size (in bytes) of the file that is processed by the
+// action act. It's used to calculate the throughput. For actions that don't
+// process a file, 0 is returned
+func (v *video) prgSize(act int) int64 {
+	var filePath string
+
+	switch act {
+	case prgActDec, prgActCut:
+		filePath = v.filePath
+	case prgActDecAC3:
+		if v.ac3 != nil {
+			filePath = v.ac3.filePath
+		}
+	}
+	if filePath == "" {
+		return 0
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// decSize returns the size (in bytes) of the decoded video file. If the video
+// is not decoded (yet), 0 is returned
+func (v *video) decSize() int64 {
+	if v.status != vidStatusDec {
+		return 0
+	}
+	info, err := os.Stat(v.filePath)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// decFilePath returns the path of the decoded video: For encoded videos, it's
+// the path the decoded video will have. For cut videos, it's the path in the
+// archive (if clean up is switched on, the decoded video has been moved
+// there) or in the "Decoded" directory
+func (v *video) decFilePath() string {
+	switch v.status {
+	case vidStatusEnc:
+		return cfg.decDirPath + "/" + v.key + "." + v.cf
+	case vidStatusCut:
+		if arcPath := cfg.arcDirPath + "/" + v.key + "." + v.ri.cf; exists(arcPath) {
+			return arcPath
+		}
+		return cfg.decDirPath + "/" + v.key + "." + v.ri.cf
+	}
+	return v.filePath
+}
+
+// throughput returns a function for a dynamic name decorator that displays
+// the throughput (in MB/s) of an action that processes a file of the given
+// size
+func throughput(size int64) func(*decor.Statistics) string {
+	return func(s *decor.Statistics) string {
+		if s.TimeElapsed <= 0 || s.Total == 0 {
+			return "--.- MB/s"
+		}
+		mb := float64(size) * float64(s.Current) / float64(s.Total) / 1e6
+		return fmt.Sprintf("%4.1f MB/s", mb/s.TimeElapsed.Seconds())
+	}
+}
+
 // newVideo allocates memory for a new video and returns a reference to that. This dedicated
 // function is necessary to make the progress bar map
 func newVideo() *video {
@@ -189,6 +285,15 @@ func (v *video) postProcessing(cf string, vErr error) error {
 					err = fmt.Errorf("%s cannot be moved to %s: %v", v.filePath, dstPath, err)
 					log.Errorf("%s cannot be moved to %s: %v", v.filePath, dstPath, err)
 				}
+				// move AC3 audio file into archive as well
+				if v.ac3 != nil {
+					dstPath = cfg.arcDirPath + "/" + path.Base(v.ac3.filePath)
+					if e := os.Rename(v.ac3.filePath, dstPath); e != nil {
+						log.Errorf("%s cannot be moved to %s: %v", v.ac3.filePath, dstPath, e)
+					} else {
+						v.ac3.filePath = dstPath
+					}
+				}
 			}
 		}
 	}
@@ -206,18 +311,46 @@ func (v *video) postProcessing(cf string, vErr error) error {
 	}
 	if v.status == vidStatusDec {
 		v.status = vidStatusCut
-		v.filePath = cfg.cutDirPath + "/" + v.key + "." + v.cf
+		v.filePath = v.cutFilePath
 	}
 
 	return err
 }
 
+// postProcessingAC3 takes the result of the decoding of the separate AC3 audio
+// file and adjusts the data in struct audio accordingly
+func (v *video) postProcessingAC3(aErr error) error {
+	var err error
+
+	// In case of error: Set processing status of the video to error
+	if aErr != nil {
+		v.res = vidResultErr
+		return nil
+	}
+
+	// If cleanup is required: Delete encoded audio file
+	if cfg.doCleanUp {
+		if err = os.Remove(v.ac3.filePath); err != nil {
+			err = fmt.Errorf("%s couldn't be deleted: %v", v.ac3.filePath, err)
+			log.Warnf("%s couldn't be deleted: %v", v.ac3.filePath, err)
+		} else {
+			log.Infof("%s has been deleted", v.ac3.filePath)
+		}
+	}
+
+	// Set new status and adjust filePath
+	v.ac3.status = vidStatusDec
+	v.ac3.filePath = cfg.decDirPath + "/" + strings.TrimSuffix(path.Base(v.ac3.filePath), otrSuffixKey)
+
+	return err
+}
+
 // prependStr builds the string that is printed left of the progress bar
 func (v *video) prependStr(act int) string {
 	var key string
 
 	// define strings for the corresponsing actions
-	actStr := [3]string{"Decode", "Load cutlist", "Cut"}
+	actStr := [4]string{"Decode", "Load cutlist", "Cut", "Decode AC3"}
 
 	// adjust key length for printing
 	if len(v.key) > prgKeyLen {
@@ -231,9 +364,9 @@ func (v *video) prependStr(act int) string {
 }
 
 // Does some cleanup before processing is started:
-// - deletes log files from former runs
-// - moves video file to the corresponding sub dir of
-//   the working dir if necessary
+//   - deletes log files from former runs
+//   - moves video file to the corresponding sub dir of
+//     the working dir if necessary
 func (v *video) preProcessing() error {
 	var errFilePath string
 	var dstPath string
@@ -244,7 +377,7 @@ func (v *video) preProcessing() error {
 	switch v.status {
 	case vidStatusEnc:
 		errFilePath += errFileSuffixDec
-	case vidStatusDec:
+	case vidStatusDec, vidStatusMrk:
 		errFilePath += errFileSuffixCut
 	}
 	_ = os.Remove(errFilePath)
@@ -257,7 +390,7 @@ func (v *video) preProcessing() error {
 	switch v.status {
 	case vidStatusEnc:
 		dstPath = cfg.encDirPath + "/" + fileName
-	case vidStatusDec:
+	case vidStatusDec, vidStatusMrk:
 		dstPath = cfg.decDirPath + "/" + fileName
 	case vidStatusCut:
 		dstPath = cfg.cutDirPath + "/" + fileName
@@ -273,6 +406,25 @@ func (v *video) preProcessing() error {
 		v.filePath = dstPath
 	}
 
+	// the AC3 audio file (if there's one) is moved into the corresponding
+	// sub dir as well
+	if v.ac3 != nil {
+		srcDir, fileName = path.Split(v.ac3.filePath)
+		switch v.ac3.status {
+		case vidStatusEnc:
+			dstPath = cfg.encDirPath + "/" + fileName
+		default:
+			dstPath = cfg.decDirPath + "/" + fileName
+		}
+		if v.ac3.filePath != dstPath {
+			if e := os.Rename(srcDir+fileName, dstPath); e != nil {
+				err = fmt.Errorf("%s cannot be moved to %s: %v", fileName, dstPath, e)
+				log.Errorf("%s cannot be moved to %s: %v", v.ac3.filePath, dstPath, e)
+			}
+			v.ac3.filePath = dstPath
+		}
+	}
+
 	return err
 }
 
@@ -282,12 +434,14 @@ func (v *video) setPrgBar(act int, prg int) {
 	//get progress bar for a combination of a video and an action
 	bar := v.getBar(act)
 
-	// update the bar
-	bar.Incr(prg - int(bar.Current()))
+	// update the bar (progress can only increase)
+	if prg > int(bar.Current()) {
+		bar.Incr(prg - int(bar.Current()))
+	}
 }
 
 // start creates a new progress container and needs to be called before any
-//progress bar is created
+// progress bar is created
 func start() {
 	// create new progress container
 	p = mpb.New(
@@ -333,7 +487,7 @@ func (v *video) string() string {
 		keyStr = v.key
 	}
 
-	return fmt.Sprintf(vidFormatStr, keyStr, v.status, clStr, resStr)
+	return fmt.Sprintf(vidFormatStr, keyStr, v.ri.quality, v.status, clStr, resStr)
 }
 
 // updateFromFile is called once another file for an already existing video
@@ -350,7 +504,7 @@ func (v *video) updateFromFile(status string, filePath string) {
 		return
 	}
 
-	if ((v.status == vidStatusEnc) && (status != vidStatusEnc)) || ((v.status == vidStatusDec) && (status == vidStatusCut)) {
+	if ((v.status == vidStatusEnc) && (status != vidStatusEnc)) || ((v.status == vidStatusDec) && (status == vidStatusCut || status == vidStatusMrk)) || ((v.status == vidStatusMrk) && (status == vidStatusCut)) {
 		v.status = status
 		v.filePath = filePath
 	} else {
@@ -365,3 +519,37 @@ func (v *video) updateFromFile(status string, filePath string) {
 		}
 	}
 }
+
+// updateAC3FromFile is called once a separate AC3 audio file for a video has
+// been read. If the video doesn't have an audio file yet, it's set. If it
+// already has one, the file with the more advanced status is kept (i.e. the
+// decoded version is preferred over the encoded one) and the other one is
+// deleted.
+func (v *video) updateAC3FromFile(status string, filePath string) {
+	var err error
+
+	// no audio file so far: take this one
+	if v.ac3 == nil {
+		v.ac3 = &audio{status: status, filePath: filePath}
+		return
+	}
+
+	// nothing to do if both audio files are the same
+	if filePath == v.ac3.filePath {
+		return
+	}
+
+	if (v.ac3.status == vidStatusEnc) && (status != vidStatusEnc) {
+		filePath, v.ac3.filePath = v.ac3.filePath, filePath
+		v.ac3.status = status
+	}
+
+	// if clean up is required: Delete obsolete file
+	if cfg.doCleanUp {
+		if err = os.Remove(filePath); err != nil {
+			log.Errorf("%s couldn't be deleted: %v", filePath, err)
+		} else {
+			log.Infof("%s has been deleted", filePath)
+		}
+	}
+}
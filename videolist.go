@@ -22,10 +22,9 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
-	"path"
 	"path/filepath"
-	"regexp"
 	"strings"
 	"sync"
 
@@ -35,50 +34,44 @@ import (
 // type video list
 type videoList map[string]*video
 
+// msgOut receives the messages that are printed while the video list is read.
+// Sub commands that write their result to stdout redirect it to stderr
+var msgOut io.Writer = os.Stdout
+
 // Takes a file path and - based on the filename - checks if it's an OTR video
 // or not. If it's no OTR video, an error is returned. If it's a video, the
-// function returns (a) the key, (b) the container format and (c) the status
+// function returns (a) the key, (b) the metadata of the recording that is
+// contained in the file name, (c) the container format and (d) the status
 // - i.e. whether it's encoded, decoded or cut
-func analyzeFile(fileName string) (string, string, string, error) {
+func analyzeFile(fileName string) (string, *recInfo, string, string, error) {
 	var (
-		key    string
+		ri     *recInfo
 		cf     string
 		status string
-		fn     string
 		err    error
 	)
 
-	// check if fileName is an OTR file
-	if re, _ := regexp.Compile(`\w+_\d{2}\.\d{2}\.\d{2}_\d{2}-\d{2}_\w+`); !re.MatchString(fileName) {
-		log.Infof("File %s is no OTR File", fileName)
-		return key, "", status, fmt.Errorf("File %s is no OTR File", fileName)
-	}
-	// check if video is encoded ...
-	if filepath.Ext(fileName) == ".otrkey" {
-		status = vidStatusEnc
-		fn = strings.TrimSuffix(fileName, ".otrkey")
-	} else {
-		// ... if not: check if video is already cut ...
-		if strings.Contains(fileName, ".cut.") {
-			status = vidStatusCut
-			fn = strings.Replace(fileName, "cut.", "", -1)
-		} else {
-			// ... otherwise video must be decoded but uncut
-			status = vidStatusDec
-			fn = fileName
-		}
+	// parse file name. If that's not possible, it's no OTR file
+	if ri, status, cf, err = parseFileName(fileName); err != nil {
+		log.Info(err.Error())
+		return "", nil, "", "", err
 	}
-	if status == "" {
-		return "", "", "", fmt.Errorf("Could not determine the status of %s", fileName)
-	}
-
-	// determine container format
-	cf = path.Ext(fn)[1:]
 
-	// determine key (=filename without extension)
-	key = fn[:len(fn)-len(path.Ext(fn))]
+	return ri.key(ri.quality), ri, cf, status, err
+}
 
-	return key, cf, status, err
+// find returns the video that belongs to vid (a key or a file name of a
+// video). If there's no such video, an error is returned
+func (vl videoList) find(vid string) (*video, error) {
+	if v := vl[vid]; v != nil {
+		return v, nil
+	}
+	for _, v := range vl {
+		if v.isFileOfVideo(vid) {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("No video found for %s", vid)
 }
 
 // print prints the video list to stdout
@@ -93,10 +86,18 @@ func (vl videoList) print() {
 	fmt.Printf("\n\033[1m\033[34m:: Summary ...\033[22m\033[39m\n")
 
 	// ... if yes: Print list
-	fmt.Printf(vidFormatStr+"\n", "Video", "Status", "CL", "Result")
+	fmt.Printf(vidFormatStr+"\n", "Video", "Qual", "Status", "CL", "Result")
 	fmt.Println("--------------------------------------------------------------------------------")
 	for _, v := range vl {
 		fmt.Println(v.string())
+		// print media information (if it has been determined)
+		if v.mi != nil {
+			fmt.Printf("  %s\n", v.mi.string())
+		}
+		// print why a cutlist has been picked (or why none has been picked)
+		if s := v.clReason(); s != "" {
+			fmt.Printf("  %s\n", s)
+		}
 	}
 	fmt.Printf("\n")
 }
@@ -113,7 +114,7 @@ func (vl videoList) process() {
 	// determine if there are videos that are relevant for executiont (as otherwise start
 	// message doesn't need to be dsplayed)
 	for _, v := range vl {
-		if v.status != vidStatusCut {
+		if !v.isDone() {
 			i++
 		}
 	}
@@ -143,10 +144,14 @@ func (vl videoList) process() {
 
 	// trigger processing for all videos in the list
 	for _, v := range vl {
-		// if video is already cut: nothing to do
-		if v.status == vidStatusCut {
+		// if video is already cut (or marked in mark mode): nothing to do
+		if v.isDone() {
 			continue
 		}
+		// marked videos are cut like decoded ones
+		if v.status == vidStatusMrk {
+			v.status = vidStatusDec
+		}
 
 		// create channel for the communication:
 		// (1) decode method        -> cut method
@@ -163,8 +168,8 @@ func (vl videoList) process() {
 		// Load cutlist for video in go routine
 		go v.loadCutlist(&wg, r)
 
-		// if videos needs to be decoded ...
-		if v.status == vidStatusEnc {
+		// if videos (or its AC3 audio file) needs to be decoded ...
+		if v.status == vidStatusEnc || (v.ac3 != nil && v.ac3.status == vidStatusEnc) {
 			// Increase waitgroup counter
 			wg.Add(1)
 			// Decode video in go routine
@@ -199,11 +204,19 @@ func (vl videoList) read(patterns []string) error {
 		status    string
 		key       string
 		cf        string
+		ri        *recInfo
 		v         *video
+		// separate AC3 audio files are collected and assigned to their
+		// videos once all files have been read
+		ac3s = make(map[string][]*audio)
+		// directories of the files of a video (to search for local cutlists)
+		dirs = make(map[string][]string)
+		// records of the cut videos (with the path of the cut video as key)
+		recs = readCutRecs()
 	)
 
 	// print status message
-	fmt.Printf("\n\033[1m\033[34m:: Read video files ...\033[22m\033[39m\n")
+	fmt.Fprintf(msgOut, "\n\033[1m\033[34m:: Read video files ...\033[22m\033[39m\n")
 
 	// add working dir and the sub dirs for enc, dec and cut to the pattern list
 	patterns = append(patterns, cfg.wrkDirPath+"/*", cfg.encDirPath+"/*", cfg.decDirPath+"/*", cfg.cutDirPath+"/*")
@@ -229,15 +242,34 @@ func (vl videoList) read(patterns []string) error {
 			_, fileName = filepath.Split(filePath)
 
 			// Update video list from filePath:
-			// Determine key and status of video
-			if key, cf, status, err = analyzeFile(fileName); err != nil {
+			// Determine key and status of video. Cut videos can have arbitrary
+			// names (see naming templates). Thus, they are identified by their
+			// cut records
+			if rec := recs[filePath]; rec != nil {
+				if ri, _, _, err = parseFileName(rec.source); err != nil {
+					log.Errorf("Cut record %s is invalid: %v", rec.recPath, err)
+					continue
+				}
+				key, cf, status = rec.key, strings.TrimPrefix(filepath.Ext(filePath), "."), vidStatusCut
+			} else if key, ri, cf, status, err = analyzeFile(fileName); err != nil {
 				continue
 			}
 			// print progress message
 			if len(fileName) > 77 {
-				fmt.Println(fileName[:77] + "...")
+				fmt.Fprintln(msgOut, fileName[:77]+"...")
 			} else {
-				fmt.Println(fileName)
+				fmt.Fprintln(msgOut, fileName)
+			}
+			// remember directory of file
+			dirs[key] = append(dirs[key], filepath.Dir(filePath))
+			// AC3 audio files are no videos of their own
+			if ri.quality == vidQualityAC3 {
+				ac3s[key] = append(ac3s[key], &audio{status: status, filePath: filePath})
+				continue
+			}
+			// decoded videos with skip files have been marked
+			if status == vidStatusDec && isMarked(key, filePath) {
+				status = vidStatusMrk
 			}
 			// update video list
 			if vl[key] != nil {
@@ -247,6 +279,7 @@ func (vl videoList) read(patterns []string) error {
 				// ... else: Create a new one and add it to the global video list
 				v = newVideo()
 				v.key = key
+				v.ri = ri
 				v.cf = cf
 				v.status = status
 				v.res = vidResultNone
@@ -256,5 +289,38 @@ func (vl videoList) read(patterns []string) error {
 		}
 	}
 
+	// cut videos that have been exported into the media server library are
+	// not in the working dir anymore. Their videos are set to status "cut"
+	// based on the cut records, so that they are not cut again
+	for filePath, rec := range recs {
+		if v := vl[rec.key]; v != nil && v.status != vidStatusCut && exists(filePath) {
+			v.updateFromFile(vidStatusCut, filePath)
+		}
+	}
+
+	// assign AC3 audio files to their videos
+	for key, as := range ac3s {
+		if vl[key] == nil {
+			log.WithFields(log.Fields{"key": key}).Warn("AC3 audio file without corresponding HD video: Ignore it")
+			continue
+		}
+		for _, a := range as {
+			vl[key].updateAC3FromFile(a.status, a.filePath)
+		}
+	}
+
+	// search for local cutlist files next to the video files, in the working
+	// dir and in the cutlist dir. In addition, the size of decoded videos is
+	// determined here, since it cannot be read safely while the videos are
+	// processed concurrently
+	for key, v := range vl {
+		v.size = v.decSize()
+		ds := append(dirs[key], cfg.wrkDirPath)
+		if cfg.clDirPath != "" {
+			ds = append(ds, cfg.clDirPath)
+		}
+		v.findCutlistFiles(ds)
+	}
+
 	return err
 }